@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tmc/langchaingo/agents"
+	"github.com/tmc/langchaingo/callbacks"
+	"github.com/tmc/langchaingo/chains"
+	"github.com/tmc/langchaingo/schema"
+)
+
+const DefaultAgentMaxIterations = 5
+
+// agentEvents forwards the agent tool calls and their results to the client as debug events.
+type agentEvents struct {
+	callbacks.SimpleHandler
+
+	emit func(data interface{}) bool
+	tool string
+}
+
+var _ callbacks.Handler = (*agentEvents)(nil)
+
+func (e *agentEvents) HandleAgentAction(_ context.Context, action schema.AgentAction) {
+	e.tool = action.Tool
+	e.emit(map[string]interface{}{
+		"tool_call": map[string]interface{}{"tool": action.Tool, "input": action.ToolInput},
+	})
+}
+
+func (e *agentEvents) HandleToolEnd(_ context.Context, output string) {
+	var result interface{} = output
+	if json.Valid([]byte(output)) {
+		result = json.RawMessage(output)
+	}
+
+	e.emit(map[string]interface{}{
+		"tool_result": map[string]interface{}{"tool": e.tool, "output": result},
+	})
+}
+
+func (e *agentEvents) HandleToolError(_ context.Context, err error) {
+	e.emit(map[string]interface{}{
+		"tool_error": map[string]interface{}{"tool": e.tool, "error": err.Error()},
+	})
+}
+
+// AgentQuery answers the user input with the tool-calling agent. It streams the same events as
+// SearchByUserQuery: debug events for every tool call and result, and the answer as a chat event.
+func (h *Handler) AgentQuery(ctx context.Context, req SearchRequest) chan *ProcessingResult {
+	resultChan := make(chan *ProcessingResult)
+
+	go func() {
+		defer func() {
+			close(resultChan)
+		}()
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		send := func(result *ProcessingResult) bool {
+			select {
+			case resultChan <- result:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		sendEvent := func(eventType EventType, data interface{}) bool {
+			return send(&ProcessingResult{Msg: Event{Type: eventType, Data: data}})
+		}
+
+		events := &agentEvents{
+			emit: func(data interface{}) bool {
+				return sendEvent(EventDebug, data)
+			},
+		}
+
+		maxIterations := h.cfg.Agent.MaxIterations
+		if maxIterations < 1 {
+			maxIterations = DefaultAgentMaxIterations
+		}
+
+		agent := agents.NewConversationalAgent(
+			h.agentLLM,
+			h.agentTools(req.Location, events),
+			agents.WithPromptPrefix(AgentSysPrompt),
+			agents.WithCallbacksHandler(events),
+		)
+		executor := agents.NewExecutor(
+			agent,
+			agents.WithMaxIterations(maxIterations),
+			agents.WithMemory(h.sessions.Memory(req.SessionID)),
+			agents.WithCallbacksHandler(events),
+			agents.WithParserErrorHandler(agents.NewParserErrorHandler(nil)),
+		)
+
+		answer, err := chains.Run(ctx, executor, req.Input, chains.WithTemperature(0))
+		if errors.Is(err, agents.ErrNotFinished) {
+			answer, err = "I couldn't find an answer to your question.", nil
+		}
+		if err != nil {
+			send(&ProcessingResult{Err: withCode(CodeLLMFailed, fmt.Errorf("agent failed: %w", err))})
+			return
+		}
+
+		if !sendEvent(EventChat, stripThinking(answer)) {
+			return
+		}
+
+		send(&ProcessingResult{
+			Err: io.EOF,
+		})
+	}()
+
+	return resultChan
+}
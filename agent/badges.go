@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+)
+
+// BadgeNormalizer maps free-text badge mentions to the canonical badge names stored on restaurants,
+// so that "plant based" filters on the "vegan" badge.
+type BadgeNormalizer struct {
+	canonical map[string]string
+}
+
+func NewBadgeNormalizer(synonyms map[string][]string) *BadgeNormalizer {
+	canonical := make(map[string]string)
+	for badge, aliases := range synonyms {
+		badge = normalizeBadge(badge)
+		canonical[badge] = badge
+		for _, alias := range aliases {
+			canonical[normalizeBadge(alias)] = badge
+		}
+	}
+
+	return &BadgeNormalizer{canonical: canonical}
+}
+
+// Normalize lowercases the badges, resolves synonyms and drops duplicates.
+func (b *BadgeNormalizer) Normalize(badges []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+
+	for _, badge := range badges {
+		badge = normalizeBadge(badge)
+		if badge == "" {
+			continue
+		}
+		if canonical, ok := b.canonical[badge]; ok {
+			badge = canonical
+		}
+		if seen[badge] {
+			continue
+		}
+		seen[badge] = true
+		result = append(result, badge)
+	}
+
+	return result
+}
+
+func normalizeBadge(badge string) string {
+	return strings.Join(strings.Fields(strings.ToLower(badge)), " ")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBadgeNormalizer(t *testing.T) {
+	normalizer := NewBadgeNormalizer(map[string][]string{
+		"Vegan":       {"plant based", "Plant-Based"},
+		"gluten free": {"coeliac", "no  gluten"},
+	})
+
+	tests := []struct {
+		name   string
+		badges []string
+		want   []string
+	}{
+		{"empty", nil, nil},
+		{"canonical badges are kept", []string{"vegan", "gluten free"}, []string{"vegan", "gluten free"}},
+		{"synonyms resolve", []string{"plant based", "coeliac"}, []string{"vegan", "gluten free"}},
+		{"case and spacing are ignored", []string{"  Plant   Based ", "NO GLUTEN"}, []string{"vegan", "gluten free"}},
+		{"hyphenated synonyms resolve", []string{"plant-based"}, []string{"vegan"}},
+		{"unknown badges are lowercased", []string{"Halal", "family  friendly"}, []string{"halal", "family friendly"}},
+		{"duplicates are dropped", []string{"vegan", "plant based", "Vegan"}, []string{"vegan"}},
+		{"blank badges are dropped", []string{"", "   ", "halal"}, []string{"halal"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizer.Normalize(tt.badges); !slices.Equal(got, tt.want) {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.badges, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imkonsowa/restaurants-rag/config"
+	"github.com/imkonsowa/restaurants-rag/lang"
+	"github.com/imkonsowa/restaurants-rag/models"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	DefaultCacheTTL               = 10 * time.Minute
+	DefaultCacheMaxEntries        = 1000
+	DefaultCacheLocationPrecision = 2
+)
+
+// cachedResult is what a cache entry keeps of a search result, rows are fetched again on a hit so that
+// they are never stale.
+type cachedResult struct {
+	RestaurantID uint64
+	ItemIDs      []uint64
+	Similarity   float64
+	Score        float64
+	Explanation  *Explanation
+}
+
+type cacheEntry struct {
+	plan      searchPlan
+	vectors   [][]float32
+	results   []cachedResult
+	more      bool // whether the page had a next page
+	expiresAt time.Time
+}
+
+type CacheStats struct {
+	Enabled       bool    `json:"enabled"`
+	Entries       int     `json:"entries"`
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	HitRate       float64 `json:"hit_rate"`
+	Invalidations uint64  `json:"invalidations"`
+}
+
+// SearchCache keeps the parsed input, query vectors and result IDs of new searches, keyed on the normalised
+// query, a location bucket and the search options. Entries are dropped on the CDC events that may change them.
+type SearchCache struct {
+	mu           sync.Mutex
+	ttl          time.Duration
+	maxEntries   int
+	precision    int
+	entries      map[string]*cacheEntry
+	byRestaurant map[uint64]map[string]struct{}
+
+	hits, misses, invalidations uint64
+}
+
+func NewSearchCache(cfg config.Cache) *SearchCache {
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntries < 1 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+	precision := cfg.LocationPrecision
+	if precision < 1 {
+		precision = DefaultCacheLocationPrecision
+	}
+
+	return &SearchCache{
+		ttl:          ttl,
+		maxEntries:   maxEntries,
+		precision:    precision,
+		entries:      make(map[string]*cacheEntry),
+		byRestaurant: make(map[uint64]map[string]struct{}),
+	}
+}
+
+// Key returns the cache key of a request.
+func (c *SearchCache) Key(req SearchRequest) string {
+	query := strings.Join(strings.Fields(strings.ToLower(lang.Normalize(req.Input))), " ")
+
+	location := "-"
+	if req.Location != nil {
+		scale := math.Pow(10, float64(c.precision))
+		location = fmt.Sprintf("%.*f,%.*f",
+			c.precision, math.Round(req.Location.Lat*scale)/scale,
+			c.precision, math.Round(req.Location.Long*scale)/scale,
+		)
+	}
+
+	options, _ := json.Marshal(req.Options)
+
+	return query + "|" + location + "|" + string(options)
+}
+
+func (c *SearchCache) Get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expiresAt) {
+		c.remove(key)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+
+	return entry, true
+}
+
+func (c *SearchCache) Put(key string, plan searchPlan, vectors [][]float32, page *SearchPage) {
+	entry := &cacheEntry{
+		plan:      plan,
+		vectors:   vectors,
+		results:   make([]cachedResult, 0, len(page.Results)),
+		more:      page.Cursor != "",
+		expiresAt: time.Now().Add(c.ttl),
+	}
+	for _, r := range page.Results {
+		cached := cachedResult{
+			RestaurantID: r.Restaurant.ID,
+			Similarity:   r.Similarity,
+			Score:        r.Score,
+			Explanation:  r.Explanation,
+		}
+		for _, item := range r.MenuItems {
+			cached.ItemIDs = append(cached.ItemIDs, item.ID)
+		}
+		entry.results = append(entry.results, cached)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+	if len(c.entries) >= c.maxEntries {
+		c.evict()
+	}
+
+	c.entries[key] = entry
+	for _, r := range entry.results {
+		keys, ok := c.byRestaurant[r.RestaurantID]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.byRestaurant[r.RestaurantID] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// InvalidateRestaurant drops the entries whose results contain the restaurant.
+func (c *SearchCache) InvalidateRestaurant(restaurantID uint64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := c.byRestaurant[restaurantID]
+	for key := range keys {
+		c.remove(key)
+	}
+	c.invalidations += uint64(len(keys))
+
+	return len(keys)
+}
+
+// Clear drops every entry, new rows may match any cached search.
+func (c *SearchCache) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.entries)
+	c.entries = make(map[string]*cacheEntry)
+	c.byRestaurant = make(map[uint64]map[string]struct{})
+	c.invalidations += uint64(n)
+
+	return n
+}
+
+func (c *SearchCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Enabled:       true,
+		Entries:       len(c.entries),
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Invalidations: c.invalidations,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits) / float64(total)
+	}
+
+	return stats
+}
+
+// remove deletes an entry and its restaurant index, the caller holds the lock.
+func (c *SearchCache) remove(key string) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	delete(c.entries, key)
+	for _, r := range entry.results {
+		if keys, ok := c.byRestaurant[r.RestaurantID]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(c.byRestaurant, r.RestaurantID)
+			}
+		}
+	}
+}
+
+// evict makes room for a new entry by dropping the expired entries, or the one closest to expiring.
+func (c *SearchCache) evict() {
+	now := time.Now()
+
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			c.remove(key)
+			continue
+		}
+		if oldestKey == "" || entry.expiresAt.Before(oldest) {
+			oldestKey, oldest = key, entry.expiresAt
+		}
+	}
+
+	if len(c.entries) >= c.maxEntries && oldestKey != "" {
+		c.remove(oldestKey)
+	}
+}
+
+// cachedSearch serves a new search from the cache. The cached plan and result ids are reused while the rows
+// are fetched again, with distances measured from the request location.
+func (h *Handler) cachedSearch(ctx context.Context, key string, req SearchRequest) (*searchPlan, *SearchPage, bool) {
+	entry, ok := h.cache.Get(key)
+	if !ok {
+		return nil, nil, false
+	}
+
+	plan := entry.plan
+	// Landmarks replace the user location, otherwise the location only shares the cached location bucket.
+	if req.Location != nil && (plan.Filter.Place == "" || plan.Filter.AreaID != 0) {
+		plan.Filter.Location = req.Location
+	}
+	plan.Debug = append(slices.Clone(plan.Debug), map[string]interface{}{"cache": "hit"})
+
+	results, err := h.pg.LoadResults(ctx, entry.results, plan.Filter.Location)
+	if err != nil {
+		slog.Warn("failed to load cached search results, searching again", "error", err)
+		return nil, nil, false
+	}
+
+	page := &SearchPage{Results: results}
+	if entry.more {
+		returnedIDs := make([]uint64, 0, len(entry.results))
+		for _, r := range entry.results {
+			returnedIDs = append(returnedIDs, r.RestaurantID)
+		}
+
+		cursor, err := h.nextPageCursor(plan.Filter, plan.Filter.Limit, returnedIDs, plan.Queries, "", entry.vectors)
+		if err != nil {
+			slog.Warn("failed to encode cached search cursor, searching again", "error", err)
+			return nil, nil, false
+		}
+		page.Cursor = cursor
+	}
+
+	return &plan, page, true
+}
+
+// cacheable reports whether a search can be served from the cache, refinements depend on the conversation.
+func (h *Handler) cacheable(req SearchRequest) bool {
+	return h.cache != nil && !req.Options.Agent && (req.State == nil || !req.State.HasSearch())
+}
+
+type cdcMessage struct {
+	Table string `json:"table"`
+	Kind  string `json:"kind"`
+	ID    uint64 `json:"id"`
+}
+
+// SubscribeCDC invalidates the cache from the CDC events of the restaurants and menu items subjects. It uses
+// plain subscriptions so that the embedder's JetStream consumers still receive every message.
+func (c *SearchCache) SubscribeCDC(nc *nats.Conn, cfg config.Nats) error {
+	handle := func(msg *nats.Msg) {
+		var change cdcMessage
+		if err := json.Unmarshal(msg.Data, &change); err != nil {
+			slog.Warn("failed to parse cdc message", "subject", msg.Subject, "error", err)
+			return
+		}
+
+		// A deleted restaurant only leaves the searches it was part of.
+		if change.Kind == "delete" && change.Table == "restaurants" {
+			if n := c.InvalidateRestaurant(change.ID); n > 0 {
+				slog.Info("invalidated search cache", "table", change.Table, "restaurant_id", change.ID, "entries", n)
+			}
+			return
+		}
+
+		// Any other change may make a restaurant match cached searches it was not part of: new rows, edits and
+		// the new vectors of the embedder, which replace results cached between an edit and its embedding.
+		if n := c.Clear(); n > 0 {
+			slog.Info("cleared search cache", "table", change.Table, "kind", change.Kind, "id", change.ID, "entries", n)
+		}
+	}
+
+	for _, subject := range []string{cfg.RestaurantsSubject, cfg.MenuItemsSubject} {
+		if _, err := nc.Subscribe(subject, handle); err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+		}
+	}
+
+	return nil
+}
+
+// LoadResults fetches the current rows of cached results, in the cached order. Restaurants and items deleted
+// since the results were cached are left out, distances and their explanation are measured from location.
+func (s *Pg) LoadResults(ctx context.Context, cached []cachedResult, location *GeoPoint) ([]SearchResult, error) {
+	if len(cached) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	restaurantIDs := make([]uint64, 0, len(cached))
+	var itemIDs []uint64
+	for _, r := range cached {
+		restaurantIDs = append(restaurantIDs, r.RestaurantID)
+		itemIDs = append(itemIDs, r.ItemIDs...)
+	}
+
+	var restaurants []models.Restaurant
+	if err := s.db.WithContext(ctx).Where("id IN ?", restaurantIDs).Find(&restaurants).Error; err != nil {
+		return nil, fmt.Errorf("fetch restaurants: %w", err)
+	}
+	restaurantMap := make(map[uint64]models.Restaurant, len(restaurants))
+	for _, r := range restaurants {
+		restaurantMap[r.ID] = r
+	}
+
+	itemMap := make(map[uint64]models.MenuItem, len(itemIDs))
+	if len(itemIDs) > 0 {
+		var items []models.MenuItem
+		if err := s.db.WithContext(ctx).Where("id IN ?", itemIDs).Find(&items).Error; err != nil {
+			return nil, fmt.Errorf("fetch menu items: %w", err)
+		}
+		for _, item := range items {
+			itemMap[item.ID] = item
+		}
+	}
+
+	facts, err := s.restaurantFacts(ctx, restaurantIDs, location)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(cached))
+	for _, r := range cached {
+		restaurant, ok := restaurantMap[r.RestaurantID]
+		if !ok {
+			continue
+		}
+		restaurant.PriceTier = facts[r.RestaurantID].PriceTier
+		restaurant.OpenNow = facts[r.RestaurantID].OpenNow
+
+		items := make([]models.MenuItem, 0, len(r.ItemIDs))
+		for _, id := range r.ItemIDs {
+			if item, ok := itemMap[id]; ok {
+				items = append(items, item)
+			}
+		}
+
+		results = append(results, SearchResult{
+			RestaurantWithMenuItems: models.RestaurantWithMenuItems{
+				Restaurant: restaurant,
+				MenuItems:  items,
+			},
+			Distance:    facts[r.RestaurantID].Distance,
+			Similarity:  r.Similarity,
+			Score:       r.Score,
+			Explanation: r.Explanation.withDistance(facts[r.RestaurantID].Distance),
+		})
+	}
+
+	return results, nil
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ConversationState is the last search of a persistent WebSocket connection. Follow-up queries are
+// parsed into a FilterDelta and applied on top of it.
+type ConversationState struct {
+	mu      sync.Mutex
+	filter  *SearchFilter
+	queries []string
+	results []SearchResult
+}
+
+func (c *ConversationState) HasSearch() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.filter != nil
+}
+
+// Update records the latest search, it is a no-op on a nil state.
+func (c *ConversationState) Update(filter SearchFilter, queries []string, results []SearchResult) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.filter = &filter
+	c.queries = queries
+	c.results = results
+}
+
+func (c *ConversationState) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.filter = nil
+	c.queries = nil
+	c.results = nil
+}
+
+func (c *ConversationState) snapshot() (SearchFilter, []string, []SearchResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	filter := *c.filter
+	filter.Badges = slices.Clone(filter.Badges)
+	filter.ExcludeIDs = slices.Clone(filter.ExcludeIDs)
+	filter.RestrictIDs = slices.Clone(filter.RestrictIDs)
+
+	return filter, slices.Clone(c.queries), slices.Clone(c.results)
+}
+
+// FilterDelta is the parser's view of a follow-up query: the changes to apply to the previous search.
+// Nil fields keep the previous value.
+type FilterDelta struct {
+	NewSearch          bool       `json:"new_search"`          // the query is unrelated to the previous search
+	Query              *string    `json:"query"`               // replaces the search text
+	Distance           *float64   `json:"distance"`            // in meters
+	Rating             *float64   `json:"rating"`              // 1-5 scale
+	MinPrice           *float64   `json:"min_price"`           // lowest acceptable menu item price
+	MaxPrice           *float64   `json:"max_price"`           // highest acceptable menu item price
+	PriceTier          *string    `json:"price_tier"`          // $, $$ or $$$
+	AddBadges          []string   `json:"add_badges"`          // badges the restaurants must also have
+	RemoveBadges       []string   `json:"remove_badges"`       // badges no longer required
+	ExcludeRestaurants []string   `json:"exclude_restaurants"` // names of previous results to drop
+	Sort               *SortOrder `json:"sort"`
+	OpenNow            *bool      `json:"open_now"`
+	OpenAt             *string    `json:"open_at"` // HH:MM local time
+	Place              *string    `json:"place"`   // area or landmark to search in or near
+	Clear              []string   `json:"clear"`   // constraints of the previous search to drop, see clearableFields
+	Narrow             bool       `json:"narrow"`  // only keep restaurants from the previous results
+}
+
+// clearableFields are the constraints a follow-up can drop with FilterDelta.Clear.
+var clearableFields = []string{
+	"distance", "rating", "min_price", "max_price", "price_tier", "badges", "sort", "open_now", "open_at", "place",
+	"exclude_restaurants",
+}
+
+func (d *FilterDelta) Validate() error {
+	if d.Distance != nil && *d.Distance <= 0 {
+		return fmt.Errorf("distance must be positive")
+	}
+	if d.Rating != nil && (*d.Rating < 1 || *d.Rating > 5) {
+		return fmt.Errorf("rating must be between 1 and 5")
+	}
+	if d.MinPrice != nil && *d.MinPrice < 0 {
+		return fmt.Errorf("min price must not be negative")
+	}
+	if d.MaxPrice != nil && *d.MaxPrice <= 0 {
+		return fmt.Errorf("max price must be positive")
+	}
+	if d.PriceTier != nil {
+		switch *d.PriceTier {
+		case "", PriceTierLow, PriceTierMid, PriceTierHigh:
+		default:
+			return fmt.Errorf("price tier must be one of $, $$ or $$$")
+		}
+	}
+	if d.Sort != nil {
+		if _, err := ParseSortOrder(string(*d.Sort)); err != nil {
+			return err
+		}
+	}
+	if d.OpenAt != nil && *d.OpenAt != "" && !validClock(*d.OpenAt) {
+		return fmt.Errorf("open_at must be a HH:MM time")
+	}
+	for _, field := range d.Clear {
+		if !slices.Contains(clearableFields, field) {
+			return fmt.Errorf("clear must only list %s", strings.Join(clearableFields, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Apply changes the filter according to the delta. Cleared constraints go back to their value in defaults before
+// the new values are set, and the previous results stay a restriction only when the follow-up narrows them again.
+// Restaurants are matched by name against the previous results.
+func (d *FilterDelta) Apply(filter *SearchFilter, defaults SearchFilter, previous []SearchResult, badges *BadgeNormalizer) {
+	for _, field := range d.Clear {
+		switch field {
+		case "distance":
+			filter.MaxDistance = defaults.MaxDistance
+		case "rating":
+			filter.MinRating = defaults.MinRating
+		case "min_price":
+			filter.MinPrice = defaults.MinPrice
+		case "max_price":
+			filter.MaxPrice = defaults.MaxPrice
+		case "price_tier":
+			filter.PriceRange = defaults.PriceRange
+		case "badges":
+			filter.Badges = defaults.Badges
+		case "sort":
+			filter.Sort = defaults.Sort
+		case "open_now":
+			filter.OpenNow = defaults.OpenNow
+		case "open_at":
+			filter.OpenAt = defaults.OpenAt
+		case "place":
+			// The distance limit of a landmark or area goes with it.
+			filter.Place = defaults.Place
+			filter.AreaID = defaults.AreaID
+			filter.Location = defaults.Location
+			filter.MaxDistance = defaults.MaxDistance
+		case "exclude_restaurants":
+			filter.ExcludeIDs = defaults.ExcludeIDs
+		}
+	}
+
+	if d.Distance != nil {
+		filter.MaxDistance = *d.Distance
+	}
+	if d.Rating != nil {
+		filter.MinRating = *d.Rating
+	}
+	if d.MinPrice != nil {
+		filter.MinPrice = d.MinPrice
+	}
+	if d.MaxPrice != nil {
+		filter.MaxPrice = d.MaxPrice
+	}
+	if d.PriceTier != nil {
+		filter.PriceRange = *d.PriceTier
+	}
+	if d.Sort != nil {
+		filter.Sort = *d.Sort
+	}
+	if d.OpenNow != nil {
+		filter.OpenNow = *d.OpenNow
+	}
+	if d.OpenAt != nil {
+		filter.OpenAt = *d.OpenAt
+	}
+
+	removed := badges.Normalize(d.RemoveBadges)
+	filter.Badges = slices.DeleteFunc(filter.Badges, func(b string) bool {
+		return slices.Contains(removed, b)
+	})
+	filter.Badges = badges.Normalize(append(filter.Badges, d.AddBadges...))
+
+	for _, name := range d.ExcludeRestaurants {
+		for _, r := range previous {
+			if strings.EqualFold(strings.TrimSpace(name), r.Restaurant.Name) && !slices.Contains(filter.ExcludeIDs, r.Restaurant.ID) {
+				filter.ExcludeIDs = append(filter.ExcludeIDs, r.Restaurant.ID)
+			}
+		}
+	}
+
+	filter.RestrictIDs = nil
+	if d.Narrow {
+		for _, r := range previous {
+			filter.RestrictIDs = append(filter.RestrictIDs, r.Restaurant.ID)
+		}
+	}
+}
+
+// planRefinement turns a follow-up query into a search by applying the parsed delta to the previous search.
+// Unrelated queries start a new search.
+func (h *Handler) planRefinement(ctx context.Context, req SearchRequest) (*searchPlan, error) {
+	filter, queries, previous := req.State.snapshot()
+
+	delta, err := h.ParseRefinement(ctx, req.Input, filter, previous)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse follow-up query: %w", err)
+	}
+	if delta.NewSearch {
+		return h.planSearch(ctx, req)
+	}
+
+	var debug []interface{}
+	// Without previous results there is nothing to narrow, the follow-up searches every restaurant.
+	if delta.Narrow && len(previous) == 0 {
+		delta.Narrow = false
+		debug = append(debug, map[string]interface{}{"narrow": "the previous search has no results, searching all restaurants"})
+	}
+
+	delta.Apply(&filter, h.defaultFilter(req.Location), previous, h.badges)
+	// A landmark from a previous turn keeps being the reference point over the user location.
+	if req.Location != nil && filter.Place == "" {
+		filter.Location = req.Location
+	}
+
+	if delta.Place != nil && strings.TrimSpace(*delta.Place) != "" {
+		placeDebug, err := h.applyPlace(ctx, &filter, *delta.Place, delta.Distance != nil)
+		if err != nil {
+			return nil, err
+		}
+		debug = append(debug, placeDebug)
+	}
+
+	req.Options.Apply(&filter)
+
+	if delta.Query != nil && strings.TrimSpace(*delta.Query) != "" {
+		queries = []string{strings.TrimSpace(*delta.Query)}
+	}
+
+	return &searchPlan{
+		Filter:  filter,
+		Queries: queries,
+		Parsed:  delta,
+		Debug: append(debug,
+			map[string]interface{}{"filter": filter},
+			map[string]interface{}{"search_queries": queries},
+		),
+	}, nil
+}
+
+func (h *Handler) ParseRefinement(
+	ctx context.Context,
+	input string,
+	filter SearchFilter,
+	previous []SearchResult,
+) (*FilterDelta, error) {
+	state, err := json.Marshal(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode previous filter: %w", err)
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString("Previous search filter: " + string(state) + "\n")
+	prompt.WriteString("Previous results:\n")
+	for _, r := range previous {
+		prompt.WriteString("- " + r.Restaurant.Stringify())
+		for _, item := range r.MenuItems {
+			prompt.WriteString(fmt.Sprintf("; %s %.2f", item.Name, item.Price))
+		}
+		prompt.WriteString("\n")
+	}
+	prompt.WriteString(fmt.Sprintf("Follow-up query: %q\nReturn only valid JSON.", input))
+
+	var delta FilterDelta
+	if err := h.generateJSON(ctx, h.parserLLM, RefineSysPrompt, prompt.String(), &delta); err != nil {
+		return nil, err
+	}
+
+	if err := delta.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &delta, nil
+}
+
+const (
+	ClientQuery = "query"
+	ClientReset = "reset"
+)
+
+// closeTimeout bounds the write of a close frame to an unresponsive client.
+const closeTimeout = time.Second
+
+// ClientMessage is sent by the web client over a persistent search connection.
+type ClientMessage struct {
+	Type     string        `json:"type"`
+	ID       string        `json:"id"` // request id echoed by the v1 protocol
+	Input    string        `json:"input"`
+	Location *GeoPoint     `json:"location"`
+	Options  SearchOptions `json:"options"`
+}
+
+// converse serves query messages until the client closes the connection. Every query refines the previous
+// search of the connection unless the parser decides it is a new one, and each turn ends with a done event.
+func (a *Agent) converse(ctx *gin.Context, c *websocket.Conn) {
+	state := &ConversationState{}
+	sessionID := sessionFromContext(ctx)
+
+	for {
+		var msg ClientMessage
+		if err := c.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Error("failed to read from ws connection", "error", err)
+			}
+			return
+		}
+
+		switch msg.Type {
+		case ClientReset:
+			state.Reset()
+		case ClientQuery:
+			req := SearchRequest{
+				Input:     msg.Input,
+				Location:  msg.Location,
+				Summary:   true,
+				Options:   msg.Options,
+				SessionID: sessionID,
+				State:     state,
+			}
+			if err := req.Validate(); err != nil {
+				if !writeError(c, err) {
+					return
+				}
+				break
+			}
+
+			for result := range a.handler.SearchByUserQuery(ctx.Request.Context(), req) {
+				if result.Err != nil {
+					if result.Err != io.EOF && !writeError(c, result.Err) {
+						return
+					}
+					break
+				}
+
+				if !writeEvent(c, result.Msg) {
+					return
+				}
+			}
+		default:
+			if !writeError(c, withCode(CodeInvalidRequest, fmt.Errorf("unknown message type %q", msg.Type))) {
+				return
+			}
+			continue
+		}
+
+		if !writeEvent(c, Event{Type: EventDone}) {
+			return
+		}
+	}
+}
+
+func writeEvent(c *websocket.Conn, event Event) bool {
+	msg, err := webSocketEvent(event)
+	if err != nil {
+		slog.Error("failed to encode ws message", "error", err)
+		return false
+	}
+
+	if err := c.WriteJSON(msg); err != nil {
+		slog.Error("failed to write to ws connection", "error", err)
+		return false
+	}
+
+	return true
+}
+
+func writeError(c *websocket.Conn, err error) bool {
+	return writeEvent(c, Event{Type: EventError, Data: errorBody(err)})
+}
+
+// closeWithError sends the error event and closes the connection with the close status of its code.
+func closeWithError(c *websocket.Conn, err error) {
+	if writeError(c, err) {
+		closeConn(c, closeStatus(err), string(errorCode(err)))
+	}
+}
+
+// closeConn sends a close frame, the connection itself is closed by the handler.
+func closeConn(c *websocket.Conn, status int, reason string) {
+	msg := websocket.FormatCloseMessage(status, reason)
+	if err := c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout)); err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+		slog.Error("failed to close ws connection", "error", err)
+	}
+}
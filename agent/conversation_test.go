@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/imkonsowa/restaurants-rag/models"
+)
+
+func result(id uint64, name string) SearchResult {
+	return SearchResult{RestaurantWithMenuItems: models.RestaurantWithMenuItems{
+		Restaurant: models.Restaurant{ID: id, Name: name},
+	}}
+}
+
+func TestFilterDeltaValidate(t *testing.T) {
+	tier := "$$$$"
+	sort := SortOrder("popularity")
+	openAt := "25:00"
+
+	tests := []struct {
+		name    string
+		delta   FilterDelta
+		wantErr bool
+	}{
+		{"empty", FilterDelta{}, false},
+		{"valid values", FilterDelta{Distance: float(500), Rating: float(4), MinPrice: float(0), MaxPrice: float(50)}, false},
+		{"clearable fields", FilterDelta{Clear: []string{"distance", "place", "exclude_restaurants"}}, false},
+		{"zero distance", FilterDelta{Distance: float(0)}, true},
+		{"rating out of range", FilterDelta{Rating: float(6)}, true},
+		{"negative min price", FilterDelta{MinPrice: float(-1)}, true},
+		{"zero max price", FilterDelta{MaxPrice: float(0)}, true},
+		{"unknown price tier", FilterDelta{PriceTier: &tier}, true},
+		{"unknown sort", FilterDelta{Sort: &sort}, true},
+		{"bad open_at", FilterDelta{OpenAt: &openAt}, true},
+		{"unknown clear field", FilterDelta{Clear: []string{"query"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.delta.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterDeltaApply(t *testing.T) {
+	badges := NewBadgeNormalizer(map[string][]string{"vegan": {"plant based"}})
+	previous := []SearchResult{result(1, "Pizza Place"), result(2, "Sushi Bar")}
+	defaults := SearchFilter{MaxDistance: 5000, Sort: SortByRelevance}
+	origin := &GeoPoint{Lat: 25.2, Long: 55.3}
+	landmark := &GeoPoint{Lat: 25.1, Long: 55.1}
+	tier := "$"
+
+	tests := []struct {
+		name   string
+		filter SearchFilter
+		delta  FilterDelta
+		want   SearchFilter
+	}{
+		{
+			name:   "empty delta keeps the filter and drops the restriction",
+			filter: SearchFilter{MaxDistance: 2000, MinRating: 4, Badges: []string{"vegan"}, RestrictIDs: []uint64{1}},
+			want:   SearchFilter{MaxDistance: 2000, MinRating: 4, Badges: []string{"vegan"}},
+		},
+		{
+			name:   "new values replace the previous ones",
+			filter: SearchFilter{MaxDistance: 2000, MinRating: 3},
+			delta:  FilterDelta{Distance: float(800), Rating: float(4.5), MaxPrice: float(40), PriceTier: &tier},
+			want:   SearchFilter{MaxDistance: 800, MinRating: 4.5, MaxPrice: float(40), PriceRange: "$"},
+		},
+		{
+			name:   "cleared fields go back to the defaults",
+			filter: SearchFilter{MaxDistance: 2000, MinRating: 4, MinPrice: float(10), Badges: []string{"halal"}, Sort: SortByRating, OpenNow: true},
+			delta:  FilterDelta{Clear: []string{"distance", "rating", "min_price", "badges", "sort", "open_now"}},
+			want:   SearchFilter{MaxDistance: 5000, Sort: SortByRelevance},
+		},
+		{
+			name:   "clearing the place drops its area and location",
+			filter: SearchFilter{Place: "Marina", AreaID: 3, Location: landmark, MaxDistance: 1000},
+			delta:  FilterDelta{Clear: []string{"place"}},
+			want:   SearchFilter{MaxDistance: 5000},
+		},
+		{
+			name:   "new values are applied after clears",
+			filter: SearchFilter{MaxDistance: 2000},
+			delta:  FilterDelta{Clear: []string{"distance"}, Distance: float(300)},
+			want:   SearchFilter{MaxDistance: 300},
+		},
+		{
+			name:   "badges are removed then added through the normalizer",
+			filter: SearchFilter{Badges: []string{"vegan", "halal"}},
+			delta:  FilterDelta{RemoveBadges: []string{"Plant Based"}, AddBadges: []string{"Family Friendly", "halal"}},
+			want:   SearchFilter{Badges: []string{"halal", "family friendly"}},
+		},
+		{
+			name:   "previous restaurants are excluded by name",
+			filter: SearchFilter{ExcludeIDs: []uint64{2}},
+			delta:  FilterDelta{ExcludeRestaurants: []string{" pizza place ", "Sushi Bar", "Unknown"}},
+			want:   SearchFilter{ExcludeIDs: []uint64{2, 1}},
+		},
+		{
+			name:   "clearing exclusions resets them",
+			filter: SearchFilter{ExcludeIDs: []uint64{1, 2}},
+			delta:  FilterDelta{Clear: []string{"exclude_restaurants"}},
+			want:   SearchFilter{},
+		},
+		{
+			name:   "narrowing restricts to the previous results",
+			filter: SearchFilter{Location: origin, RestrictIDs: []uint64{7}},
+			delta:  FilterDelta{Narrow: true},
+			want:   SearchFilter{Location: origin, RestrictIDs: []uint64{1, 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := tt.filter
+			tt.delta.Apply(&filter, defaults, previous, badges)
+
+			if !reflect.DeepEqual(filter, tt.want) {
+				t.Errorf("Apply() = %+v, want %+v", filter, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCursorTTL        = 30 * time.Minute
+	DefaultCursorEmbeddings = 5000
+)
+
+// SearchCursor is the state needed to fetch the next page of a search without running the parser again.
+// It is sent to clients encrypted by a CursorCodec.
+type SearchCursor struct {
+	Filter       SearchFilter `json:"filter"`
+	Queries      []string     `json:"queries"`
+	EmbeddingRef string       `json:"embedding_ref"`
+}
+
+// Validate runs the checks of the search options on the cursor state, cursors are never trusted.
+func (c *SearchCursor) Validate() error {
+	if len(c.Queries) == 0 {
+		return fmt.Errorf("cursor has no query")
+	}
+
+	return c.Filter.Validate()
+}
+
+// CursorCodec seals cursors with AES-GCM: clients can neither read nor forge the filter they carry.
+type CursorCodec struct {
+	aead cipher.AEAD
+}
+
+// NewCursorCodec derives the key from the secret. Without a secret a random key is used, cursors then stop
+// working on restart like the query vectors they reference.
+func NewCursorCodec(secret string) (*CursorCodec, error) {
+	key := make([]byte, sha256.Size)
+	if secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		key = sum[:]
+	} else if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate cursor key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cursor cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cursor cipher: %w", err)
+	}
+
+	return &CursorCodec{aead: aead}, nil
+}
+
+func (c *CursorCodec) Encode(cursor SearchCursor) (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(c.aead.Seal(nonce, nonce, data, nil)), nil
+}
+
+func (c *CursorCodec) Decode(encoded string) (*SearchCursor, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return nil, withCode(CodeInvalidRequest, fmt.Errorf("invalid cursor"))
+	}
+
+	nonce, sealed := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	data, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, withCode(CodeInvalidRequest, fmt.Errorf("invalid or expired cursor"))
+	}
+
+	var cursor SearchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, withCode(CodeInvalidRequest, fmt.Errorf("invalid cursor: %w", err))
+	}
+	if err := cursor.Validate(); err != nil {
+		return nil, withCode(CodeInvalidRequest, fmt.Errorf("invalid cursor: %w", err))
+	}
+
+	return &cursor, nil
+}
+
+type SearchPage struct {
+	Results []SearchResult `json:"results"`
+	Cursor  string         `json:"cursor,omitempty"`
+}
+
+// newSearchPage keeps the first pageSize results and, when more are available, adds a cursor that
+// excludes every restaurant returned so far. embeddingRef references queryVectors when they are already stored.
+func (h *Handler) newSearchPage(
+	results []SearchResult,
+	pageSize int,
+	filter SearchFilter,
+	queries []string,
+	embeddingRef string,
+	queryVectors [][]float32,
+) (*SearchPage, error) {
+	if len(results) <= pageSize {
+		return &SearchPage{Results: results}, nil
+	}
+
+	page := &SearchPage{Results: results[:pageSize]}
+
+	returnedIDs := make([]uint64, 0, pageSize)
+	for _, r := range page.Results {
+		returnedIDs = append(returnedIDs, r.Restaurant.ID)
+	}
+
+	cursor, err := h.nextPageCursor(filter, pageSize, returnedIDs, queries, embeddingRef, queryVectors)
+	if err != nil {
+		return nil, err
+	}
+	page.Cursor = cursor
+
+	return page, nil
+}
+
+// nextPageCursor encodes the cursor of the page following the one that returned the given restaurants. The
+// query vectors are stored for the cursor unless embeddingRef already references them.
+func (h *Handler) nextPageCursor(
+	filter SearchFilter,
+	pageSize int,
+	returnedIDs []uint64,
+	queries []string,
+	embeddingRef string,
+	queryVectors [][]float32,
+) (string, error) {
+	if embeddingRef == "" {
+		embeddingRef = h.embeddings.Put(queryVectors)
+	}
+
+	next := filter
+	next.Limit = pageSize
+	next.ExcludeIDs = append(slices.Clone(filter.ExcludeIDs), returnedIDs...)
+
+	return h.cursors.Encode(SearchCursor{
+		Filter:       next,
+		Queries:      queries,
+		EmbeddingRef: embeddingRef,
+	})
+}
+
+// NextPage returns the page described by the cursor. Query vectors are reused while they are cached and
+// recomputed from the cursor queries otherwise.
+func (h *Handler) NextPage(ctx context.Context, encoded string) (*SearchPage, error) {
+	cursor, err := h.cursors.Decode(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	embeddingRef := cursor.EmbeddingRef
+	queryVectors, ok := h.embeddings.Get(embeddingRef)
+	if !ok {
+		queryVectors, err = h.embedQueries(ctx, cursor.Queries)
+		if err != nil {
+			return nil, err
+		}
+		embeddingRef = ""
+	}
+
+	filter := cursor.Filter
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+
+	results, err := h.pg.Search(ctx, cursor.Queries[0], queryVectors, filter)
+	if err != nil {
+		return nil, withCode(CodeSearchFailed, fmt.Errorf("search failed: %w", err))
+	}
+
+	return h.newSearchPage(results, pageSize, cursor.Filter, cursor.Queries, embeddingRef, queryVectors)
+}
+
+type embeddingEntry struct {
+	vectors   [][]float32
+	expiresAt time.Time
+}
+
+// EmbeddingStore keeps query vectors in memory so that cursors can reference them instead of carrying them.
+// It holds at most maxEntries vectors, cursors whose vectors were dropped embed their queries again.
+type EmbeddingStore struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]embeddingEntry
+}
+
+func NewEmbeddingStore(ttl time.Duration, maxEntries int) *EmbeddingStore {
+	if ttl <= 0 {
+		ttl = DefaultCursorTTL
+	}
+	if maxEntries < 1 {
+		maxEntries = DefaultCursorEmbeddings
+	}
+
+	return &EmbeddingStore{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]embeddingEntry),
+	}
+}
+
+func (s *EmbeddingStore) Put(vectors [][]float32) string {
+	ref := randomID()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.entries) >= s.maxEntries {
+		s.evict()
+	}
+	s.entries[ref] = embeddingEntry{vectors: vectors, expiresAt: time.Now().Add(s.ttl)}
+
+	return ref
+}
+
+// evict makes room for new vectors by dropping the expired entries, or the one closest to expiring. The caller
+// holds the lock.
+func (s *EmbeddingStore) evict() {
+	now := time.Now()
+
+	var oldestRef string
+	var oldest time.Time
+	for ref, e := range s.entries {
+		if now.After(e.expiresAt) {
+			delete(s.entries, ref)
+			continue
+		}
+		if oldestRef == "" || e.expiresAt.Before(oldest) {
+			oldestRef, oldest = ref, e.expiresAt
+		}
+	}
+
+	if len(s.entries) >= s.maxEntries && oldestRef != "" {
+		delete(s.entries, oldestRef)
+	}
+}
+
+func (s *EmbeddingStore) Get(ref string) ([][]float32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[ref]
+	if !ok || time.Now().After(e.expiresAt) {
+		return nil, false
+	}
+
+	return e.vectors, true
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
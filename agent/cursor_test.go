@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCursorCodecRoundTrip(t *testing.T) {
+	codec, err := NewCursorCodec("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cursor := SearchCursor{
+		Filter: SearchFilter{
+			Limit:       10,
+			MaxDistance: 2000,
+			MinPrice:    float(15),
+			Location:    &GeoPoint{Lat: 25.2, Long: 55.3},
+			Badges:      []string{"vegan"},
+			ExcludeIDs:  []uint64{1, 2},
+			Sort:        SortByRating,
+		},
+		Queries:      []string{"pizza", "italian pizza"},
+		EmbeddingRef: "ref",
+	}
+
+	encoded, err := codec.Encode(cursor)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	decoded, err := codec.Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(*decoded, cursor) {
+		t.Errorf("Decode() = %+v, want %+v", *decoded, cursor)
+	}
+
+	again, err := codec.Encode(cursor)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if again == encoded {
+		t.Error("Encode() reused a nonce")
+	}
+}
+
+func TestCursorCodecRejects(t *testing.T) {
+	codec, err := NewCursorCodec("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCursorCodec("other secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := SearchCursor{Filter: SearchFilter{Limit: 10}, Queries: []string{"pizza"}}
+	encoded, err := codec.Encode(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := base64.RawURLEncoding.DecodeString(encoded)
+	sealed[len(sealed)-1] ^= 1
+	tampered := base64.RawURLEncoding.EncodeToString(sealed)
+
+	foreign, err := other.Encode(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noQuery, err := codec.Encode(SearchCursor{Filter: SearchFilter{Limit: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	badFilter, err := codec.Encode(SearchCursor{Filter: SearchFilter{Limit: MaxResultLimit + 1}, Queries: []string{"pizza"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"shorter than the nonce", base64.RawURLEncoding.EncodeToString([]byte("short"))},
+		{"tampered", tampered},
+		{"other secret", foreign},
+		{"no query", noQuery},
+		{"invalid filter", badFilter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := codec.Decode(tt.encoded)
+			if err == nil {
+				t.Fatal("Decode() accepted the cursor")
+			}
+			if code := errorCode(err); code != CodeInvalidRequest {
+				t.Errorf("error code = %q, want %q", code, CodeInvalidRequest)
+			}
+		})
+	}
+}
+
+func TestEmbeddingStore(t *testing.T) {
+	store := NewEmbeddingStore(time.Minute, 2)
+
+	first := store.Put([][]float32{{1}})
+	second := store.Put([][]float32{{2}})
+	third := store.Put([][]float32{{3}})
+
+	if _, ok := store.Get(first); ok {
+		t.Error("the oldest vectors were kept past the cap")
+	}
+	for ref, want := range map[string]float32{second: 2, third: 3} {
+		vectors, ok := store.Get(ref)
+		if !ok || vectors[0][0] != want {
+			t.Errorf("Get(%q) = %v, %v, want %v", ref, vectors, ok, want)
+		}
+	}
+	if _, ok := store.Get("unknown"); ok {
+		t.Error("Get() found an unknown ref")
+	}
+
+	expiring := NewEmbeddingStore(time.Nanosecond, 2)
+	ref := expiring.Put([][]float32{{1}})
+	time.Sleep(time.Millisecond)
+	if _, ok := expiring.Get(ref); ok {
+		t.Error("Get() returned expired vectors")
+	}
+}
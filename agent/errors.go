@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ErrorCode tells clients which step of a search failed.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest       ErrorCode = "invalid_request"
+	CodeInvalidLocation      ErrorCode = "invalid_location"
+	CodeParserFailed         ErrorCode = "parser_failed"
+	CodeEmbeddingUnavailable ErrorCode = "embedding_unavailable"
+	CodeSearchFailed         ErrorCode = "search_failed"
+	CodeLLMFailed            ErrorCode = "llm_failed"
+	CodeNotFound             ErrorCode = "not_found"
+	CodeConflict             ErrorCode = "conflict"
+	CodeStorageFailed        ErrorCode = "storage_failed"
+)
+
+var (
+	ErrNotFound = errors.New("record not found")
+	ErrConflict = errors.New("record was modified") // updated_at no longer matches the version being edited
+)
+
+// SearchError is an error of the search pipeline with the code sent to clients.
+type SearchError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *SearchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SearchError) Unwrap() error {
+	return e.Err
+}
+
+// withCode attaches a code to err. Errors already carrying a code keep it, the innermost step is the one that failed.
+func withCode(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var searchErr *SearchError
+	if errors.As(err, &searchErr) {
+		return err
+	}
+
+	return &SearchError{Code: code, Err: err}
+}
+
+// errorCode returns the code of err, errors without one are search failures.
+func errorCode(err error) ErrorCode {
+	var searchErr *SearchError
+	if errors.As(err, &searchErr) {
+		return searchErr.Code
+	}
+
+	return CodeSearchFailed
+}
+
+// httpStatus maps the code of err to the status of HTTP responses.
+func httpStatus(err error) int {
+	switch errorCode(err) {
+	case CodeInvalidRequest, CodeInvalidLocation:
+		return http.StatusBadRequest
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeEmbeddingUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// closeStatus maps the code of err to the status of the WebSocket close frame.
+func closeStatus(err error) int {
+	switch errorCode(err) {
+	case CodeInvalidRequest, CodeInvalidLocation:
+		return websocket.ClosePolicyViolation
+	case CodeEmbeddingUnavailable:
+		return websocket.CloseTryAgainLater
+	default:
+		return websocket.CloseInternalServerErr
+	}
+}
+
+func errorBody(err error) gin.H {
+	body := gin.H{"error": err.Error(), "code": errorCode(err)}
+
+	var fields FieldErrors
+	if errors.As(err, &fields) {
+		body["fields"] = fields
+	}
+
+	return body
+}
+
+// FieldErrors are the validation failures of a request body, keyed by JSON field.
+type FieldErrors map[string]string
+
+// Add records the first failure of a field.
+func (f FieldErrors) Add(field, message string) {
+	if _, ok := f[field]; !ok {
+		f[field] = message
+	}
+}
+
+func (f FieldErrors) Error() string {
+	fields := make([]string, 0, len(f))
+	for field := range f {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for i, field := range fields {
+		fields[i] = field + " " + f[field]
+	}
+
+	return "invalid fields: " + strings.Join(fields, ", ")
+}
+
+// Err returns the failures as an invalid_request error, or nil when there are none.
+func (f FieldErrors) Err() error {
+	if len(f) == 0 {
+		return nil
+	}
+
+	return withCode(CodeInvalidRequest, f)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type EventType string
+
+const (
+	EventParsed      EventType = "parsed"
+	EventDebug       EventType = "debug"
+	EventRestaurants EventType = "restaurants"
+	EventChat        EventType = "chat"
+	EventThinking    EventType = "thinking"
+	EventGrounding   EventType = "grounding"
+	EventError       EventType = "error"
+	EventDone        EventType = "done"
+)
+
+// Event is a single step of the search pipeline. It is shared by the WebSocket, SSE and REST transports.
+type Event struct {
+	Type EventType   `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// webSocketEvent keeps the payload of the unversioned WebSocket, where the parsed input is a debug event and
+// the restaurants page is sent as a JSON encoded string.
+func webSocketEvent(event Event) (Event, error) {
+	if event.Type == EventParsed {
+		return Event{Type: EventDebug, Data: event.Data}, nil
+	}
+	if event.Type != EventRestaurants {
+		return event, nil
+	}
+
+	res, err := json.Marshal(event.Data)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to marshal results: %w", err)
+	}
+
+	return Event{Type: event.Type, Data: string(res)}, nil
+}
+
+type SearchRequest struct {
+	Input    string        `json:"input"`
+	Location *GeoPoint     `json:"location"`
+	Summary  bool          `json:"summary"`
+	Options  SearchOptions `json:"options"`
+
+	SessionID string             `json:"-"`
+	State     *ConversationState `json:"-"` // previous search of a persistent connection, nil for one-shot searches
+}
+
+func (r *SearchRequest) Validate() error {
+	if strings.TrimSpace(r.Input) == "" {
+		return withCode(CodeInvalidRequest, fmt.Errorf("input is required"))
+	}
+
+	if r.Location != nil {
+		if r.Location.Lat < -90 || r.Location.Lat > 90 {
+			return withCode(CodeInvalidLocation, fmt.Errorf("invalid latitude"))
+		}
+		if r.Location.Long < -180 || r.Location.Long > 180 {
+			return withCode(CodeInvalidLocation, fmt.Errorf("invalid longitude"))
+		}
+	}
+
+	return withCode(CodeInvalidRequest, r.Options.Validate())
+}
+
+type SearchResponse struct {
+	Results   []SearchResult `json:"results"`
+	Cursor    string         `json:"cursor,omitempty"`
+	Parsed    interface{}    `json:"parsed,omitempty"`
+	Summary   string         `json:"summary,omitempty"`
+	Grounding *Grounding     `json:"grounding,omitempty"`
+	Debug     []interface{}  `json:"debug,omitempty"`
+}
+
+// Search runs the pipeline and collects its events into a single response.
+func (h *Handler) Search(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
+	resp := &SearchResponse{Results: []SearchResult{}}
+	var summary strings.Builder
+
+	for result := range h.SearchByUserQuery(ctx, req) {
+		if result.Err != nil {
+			if result.Err == io.EOF {
+				break
+			}
+			return nil, result.Err
+		}
+
+		switch result.Msg.Type {
+		case EventParsed:
+			resp.Parsed = result.Msg.Data
+		case EventDebug:
+			resp.Debug = append(resp.Debug, result.Msg.Data)
+		case EventRestaurants:
+			if page, ok := result.Msg.Data.(*SearchPage); ok {
+				resp.Results = page.Results
+				resp.Cursor = page.Cursor
+			}
+		case EventChat:
+			summary.WriteString(fmt.Sprint(result.Msg.Data))
+		case EventGrounding:
+			if grounding, ok := result.Msg.Data.(*Grounding); ok {
+				resp.Grounding = grounding
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	resp.Summary = summary.String()
+
+	return resp, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/imkonsowa/restaurants-rag/models"
+)
+
+const (
+	SignalRestaurant = "restaurant"
+
+	FilterDistance  = "distance"
+	FilterRating    = "rating"
+	FilterMinPrice  = "min_price"
+	FilterMaxPrice  = "max_price"
+	FilterPriceTier = "price_tier"
+	FilterBadges    = "badges"
+	FilterOpen      = "open"
+	FilterArea      = "area"
+	FilterBounds    = "bounds"
+)
+
+// Explanation tells why a restaurant is part of the results.
+type Explanation struct {
+	MatchedItems []MatchedItem      `json:"matched_items"`
+	Filters      []FilterCheck      `json:"filters"`
+	Signals      map[string]float64 `json:"signals"`          // contribution of each retrieval signal to the score
+	Rerank       *float64           `json:"rerank,omitempty"` // relevance given by the reranker, it decides the final order
+}
+
+type MatchedItem struct {
+	ID          uint64             `json:"id"`
+	Name        string             `json:"name"`
+	Similarity  float64            `json:"similarity"`
+	KeywordRank float64            `json:"keyword_rank,omitempty"`
+	Score       float64            `json:"score"`
+	Signals     map[string]float64 `json:"signals"`
+}
+
+// FilterCheck is a filter the restaurant passed. Margin is how far inside the limit the value is, it is
+// omitted for filters without a numeric value.
+type FilterCheck struct {
+	Filter string      `json:"filter"`
+	Limit  interface{} `json:"limit"`
+	Value  interface{} `json:"value,omitempty"`
+	Margin *float64    `json:"margin,omitempty"`
+}
+
+func newMatchedItem(item rankedItem) MatchedItem {
+	return MatchedItem{
+		ID:          item.ID,
+		Name:        item.Name,
+		Similarity:  item.Similarity,
+		KeywordRank: item.KeywordRank,
+		Score:       item.Score,
+		Signals:     item.Signals,
+	}
+}
+
+// explainMatch builds the explanation of a restaurant from its match, the facts loaded for sorting and the
+// filter of the search.
+func explainMatch(m *restaurantMatch, fact restaurantFact, restaurant models.Restaurant, filter SearchFilter) *Explanation {
+	explanation := &Explanation{
+		MatchedItems: m.matchedItems,
+		Filters:      []FilterCheck{},
+		Signals:      m.signals,
+	}
+	if explanation.MatchedItems == nil {
+		explanation.MatchedItems = []MatchedItem{}
+	}
+
+	if filter.Bounds != "" {
+		explanation.Filters = append(explanation.Filters, FilterCheck{Filter: FilterBounds, Limit: "map area"})
+	} else if filter.MaxDistance > 0 && filter.Location != nil && fact.Distance != nil {
+		explanation.Filters = append(explanation.Filters, FilterCheck{
+			Filter: FilterDistance,
+			Limit:  filter.MaxDistance,
+			Value:  *fact.Distance,
+			Margin: margin(filter.MaxDistance - *fact.Distance),
+		})
+	}
+	if filter.MinRating > 0 {
+		explanation.Filters = append(explanation.Filters, FilterCheck{
+			Filter: FilterRating,
+			Limit:  filter.MinRating,
+			Value:  fact.Rating,
+			Margin: margin(fact.Rating - filter.MinRating),
+		})
+	}
+
+	// Restaurants found through their own embedding passed the price filters with an unmatched item,
+	// so only the matched items give a value to compare.
+	if filter.MinPrice != nil {
+		check := FilterCheck{Filter: FilterMinPrice, Limit: *filter.MinPrice}
+		if len(m.items) > 0 {
+			check.Value = m.minItemPrice
+			check.Margin = margin(m.minItemPrice - *filter.MinPrice)
+		}
+		explanation.Filters = append(explanation.Filters, check)
+	}
+	if filter.MaxPrice != nil {
+		check := FilterCheck{Filter: FilterMaxPrice, Limit: *filter.MaxPrice}
+		if len(m.items) > 0 {
+			check.Value = m.minItemPrice
+			check.Margin = margin(*filter.MaxPrice - m.minItemPrice)
+		}
+		explanation.Filters = append(explanation.Filters, check)
+	}
+	if filter.PriceRange != "" {
+		explanation.Filters = append(explanation.Filters, FilterCheck{
+			Filter: FilterPriceTier,
+			Limit:  filter.PriceRange,
+			Value:  fact.PriceTier,
+		})
+	}
+	if filter.AreaID != 0 {
+		explanation.Filters = append(explanation.Filters, FilterCheck{
+			Filter: FilterArea,
+			Limit:  filter.Place,
+			Value:  restaurant.Area,
+		})
+	}
+	// Opening hours are checked in the restaurant's local time, the value is its timezone.
+	if filter.OpenNow {
+		explanation.Filters = append(explanation.Filters, FilterCheck{
+			Filter: FilterOpen,
+			Limit:  "now",
+			Value:  restaurant.Timezone,
+		})
+	}
+	if filter.OpenAt != "" {
+		explanation.Filters = append(explanation.Filters, FilterCheck{
+			Filter: FilterOpen,
+			Limit:  filter.OpenAt,
+			Value:  restaurant.Timezone,
+		})
+	}
+	if len(filter.Badges) > 0 {
+		var matched []string
+		for _, badge := range restaurant.Badges {
+			if slices.Contains(filter.Badges, strings.ToLower(badge)) {
+				matched = append(matched, badge)
+			}
+		}
+		explanation.Filters = append(explanation.Filters, FilterCheck{
+			Filter: FilterBadges,
+			Limit:  filter.Badges,
+			Value:  matched,
+		})
+	}
+
+	return explanation
+}
+
+// withDistance returns a copy of the explanation with the distance check measured again, for results found
+// from another location. Without a distance the check is left out.
+func (e *Explanation) withDistance(distance *float64) *Explanation {
+	if e == nil {
+		return nil
+	}
+
+	relocated := *e
+	relocated.Filters = make([]FilterCheck, 0, len(e.Filters))
+	for _, check := range e.Filters {
+		if check.Filter == FilterDistance {
+			limit, ok := check.Limit.(float64)
+			if !ok || distance == nil {
+				continue
+			}
+			check.Value = *distance
+			check.Margin = margin(limit - *distance)
+		}
+		relocated.Filters = append(relocated.Filters, check)
+	}
+
+	return &relocated
+}
+
+func margin(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/imkonsowa/restaurants-rag/models"
+)
+
+const (
+	FusionRRF      = "rrf"
+	FusionWeighted = "weighted"
+
+	DefaultRRFK = 60
+
+	DefaultVectorCandidates  = 200 // nearest menu items fetched per query vector
+	DefaultKeywordCandidates = 200 // best ranked menu items fetched by the keyword search
+
+	SignalVector  = "vector"
+	SignalKeyword = "keyword"
+)
+
+type rankedItem struct {
+	models.MenuItem
+	Similarity  float64
+	KeywordRank float64
+	Score       float64            `gorm:"-"`
+	Signals     map[string]float64 `gorm:"-"` // contribution of each signal to Score
+}
+
+func (r rankedItem) signalScore(signal string) float64 {
+	if signal == SignalKeyword {
+		return r.KeywordRank
+	}
+
+	return r.Similarity
+}
+
+// itemRanking is a single ranked candidate list produced by one retrieval signal.
+type itemRanking struct {
+	Signal string
+	Weight float64
+	Items  []rankedItem
+}
+
+// fuseRankings merges several rankings of menu items into one list ordered by the fused score.
+// With reciprocal rank fusion every list contributes weight/(k+rank), while the weighted method sums
+// the signal scores normalised by the best score of their list.
+func fuseRankings(rankings []itemRanking, method string, k float64) []rankedItem {
+	if k <= 0 {
+		k = DefaultRRFK
+	}
+
+	fused := make(map[uint64]*rankedItem)
+	var order []uint64
+
+	for _, ranking := range rankings {
+		var maxScore float64
+		for _, item := range ranking.Items {
+			if s := item.signalScore(ranking.Signal); s > maxScore {
+				maxScore = s
+			}
+		}
+
+		for rank, item := range ranking.Items {
+			f, exists := fused[item.ID]
+			if !exists {
+				f = &rankedItem{MenuItem: item.MenuItem, Signals: make(map[string]float64)}
+				fused[item.ID] = f
+				order = append(order, item.ID)
+			}
+			if item.Similarity > f.Similarity {
+				f.Similarity = item.Similarity
+			}
+			if item.KeywordRank > f.KeywordRank {
+				f.KeywordRank = item.KeywordRank
+			}
+
+			var contribution float64
+			switch method {
+			case FusionWeighted:
+				if maxScore > 0 {
+					contribution = ranking.Weight * item.signalScore(ranking.Signal) / maxScore
+				}
+			default:
+				contribution = ranking.Weight / (k + float64(rank+1))
+			}
+			f.Score += contribution
+			f.Signals[ranking.Signal] += contribution
+		}
+	}
+
+	results := make([]rankedItem, 0, len(order))
+	for _, id := range order {
+		results = append(results, *fused[id])
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	return results
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/imkonsowa/restaurants-rag/models"
+)
+
+func ranked(id uint64, similarity, keywordRank float64) rankedItem {
+	return rankedItem{MenuItem: models.MenuItem{ID: id}, Similarity: similarity, KeywordRank: keywordRank}
+}
+
+func TestFuseRankings(t *testing.T) {
+	tests := []struct {
+		name      string
+		rankings  []itemRanking
+		method    string
+		k         float64
+		wantOrder []uint64
+		wantScore map[uint64]float64
+	}{
+		{
+			name:      "no rankings",
+			method:    FusionRRF,
+			k:         60,
+			wantOrder: []uint64{},
+		},
+		{
+			name: "rrf sums reciprocal ranks",
+			rankings: []itemRanking{
+				{Signal: SignalVector, Weight: 1, Items: []rankedItem{ranked(1, 0.9, 0), ranked(2, 0.8, 0)}},
+				{Signal: SignalKeyword, Weight: 1, Items: []rankedItem{ranked(2, 0, 0.5), ranked(3, 0, 0.4)}},
+			},
+			method:    FusionRRF,
+			k:         60,
+			wantOrder: []uint64{2, 1, 3},
+			wantScore: map[uint64]float64{1: 1.0 / 61, 2: 1.0/62 + 1.0/61, 3: 1.0 / 62},
+		},
+		{
+			name: "rrf uses the default k",
+			rankings: []itemRanking{
+				{Signal: SignalVector, Weight: 1, Items: []rankedItem{ranked(1, 0.9, 0)}},
+			},
+			method:    FusionRRF,
+			wantOrder: []uint64{1},
+			wantScore: map[uint64]float64{1: 1.0 / (DefaultRRFK + 1)},
+		},
+		{
+			name: "rrf weights lists",
+			rankings: []itemRanking{
+				{Signal: SignalVector, Weight: 2, Items: []rankedItem{ranked(1, 0.9, 0)}},
+				{Signal: SignalKeyword, Weight: 1, Items: []rankedItem{ranked(2, 0, 0.5)}},
+			},
+			method:    FusionRRF,
+			k:         60,
+			wantOrder: []uint64{1, 2},
+			wantScore: map[uint64]float64{1: 2.0 / 61, 2: 1.0 / 61},
+		},
+		{
+			name: "weighted normalises by the best score of each list",
+			rankings: []itemRanking{
+				{Signal: SignalVector, Weight: 1, Items: []rankedItem{ranked(1, 0.9, 0), ranked(2, 0.45, 0)}},
+				{Signal: SignalKeyword, Weight: 1, Items: []rankedItem{ranked(2, 0, 0.2)}},
+			},
+			method:    FusionWeighted,
+			k:         60,
+			wantOrder: []uint64{2, 1},
+			wantScore: map[uint64]float64{1: 1, 2: 1.5},
+		},
+		{
+			name: "weighted ignores lists without scores",
+			rankings: []itemRanking{
+				{Signal: SignalKeyword, Weight: 1, Items: []rankedItem{ranked(1, 0, 0)}},
+			},
+			method:    FusionWeighted,
+			wantOrder: []uint64{1},
+			wantScore: map[uint64]float64{1: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fuseRankings(tt.rankings, tt.method, tt.k)
+
+			if len(got) != len(tt.wantOrder) {
+				t.Fatalf("got %d items, want %d", len(got), len(tt.wantOrder))
+			}
+			for i, item := range got {
+				if item.ID != tt.wantOrder[i] {
+					t.Errorf("item %d is %d, want %d", i, item.ID, tt.wantOrder[i])
+				}
+				if want, ok := tt.wantScore[item.ID]; ok && math.Abs(item.Score-want) > 1e-9 {
+					t.Errorf("score of %d = %v, want %v", item.ID, item.Score, want)
+				}
+
+				var signals float64
+				for _, contribution := range item.Signals {
+					signals += contribution
+				}
+				if math.Abs(signals-item.Score) > 1e-9 {
+					t.Errorf("signals of %d sum to %v, score is %v", item.ID, signals, item.Score)
+				}
+			}
+		})
+	}
+}
+
+func TestFuseRankingsKeepsBestSignalScores(t *testing.T) {
+	got := fuseRankings([]itemRanking{
+		{Signal: SignalVector, Weight: 1, Items: []rankedItem{ranked(1, 0.7, 0)}},
+		{Signal: SignalVector, Weight: 1, Items: []rankedItem{ranked(1, 0.9, 0)}},
+		{Signal: SignalKeyword, Weight: 1, Items: []rankedItem{ranked(1, 0, 0.3)}},
+	}, FusionRRF, 60)
+
+	if len(got) != 1 {
+		t.Fatalf("got %d items, want 1", len(got))
+	}
+	if got[0].Similarity != 0.9 || got[0].KeywordRank != 0.3 {
+		t.Errorf("got similarity %v and keyword rank %v, want 0.9 and 0.3", got[0].Similarity, got[0].KeywordRank)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm/clause"
+)
+
+const (
+	PlaceArea     = "area"
+	PlaceLandmark = "landmark"
+
+	DefaultLandmarkDistance = 2000 // 2km around a landmark
+
+	// Names shorter than minPartialPlaceName only match places exactly, and a partial match must cover at
+	// least half of the place name, so that "el" or "new" are not resolved to the first place containing them.
+	minPartialPlaceName = 4
+)
+
+// likeEscaper escapes the LIKE wildcards of user text, the backslash is the default escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Place is a gazetteer entry. Areas constrain the search to their polygon, landmarks replace the user location.
+type Place struct {
+	ID     uint64   `json:"id"`
+	Name   string   `json:"name"`
+	Kind   string   `json:"kind"`
+	Center GeoPoint `json:"center"`
+}
+
+// ResolvePlace finds a place by name or alias, exact matches first. Names of minPartialPlaceName letters or more
+// also match places whose name contains them. It returns nil when no place matches.
+func (s *Pg) ResolvePlace(ctx context.Context, name string) (*Place, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil, nil
+	}
+
+	matches := s.db.Where("lower(name) = ?", name).
+		Or("? = ANY (SELECT lower(alias) FROM unnest(aliases) AS alias)", name)
+	if length := utf8.RuneCountInString(name); length >= minPartialPlaceName {
+		matches = matches.Or("lower(name) LIKE ? AND char_length(name) <= ?", "%"+likeEscaper.Replace(name)+"%", 2*length)
+	}
+
+	// Places use the restaurants axis order, x is the latitude.
+	var places []struct {
+		ID        uint64
+		Name      string
+		Kind      string
+		Lat, Long float64
+	}
+	if err := s.db.WithContext(ctx).
+		Table("places").
+		Select("id, name, kind, ST_X(ST_Centroid(geom)) AS lat, ST_Y(ST_Centroid(geom)) AS long").
+		Where(matches).
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "lower(name) = ? DESC, length(name)", Vars: []interface{}{name}}}).
+		Limit(1).
+		Scan(&places).Error; err != nil {
+		return nil, fmt.Errorf("failed to resolve place: %w", err)
+	}
+	if len(places) == 0 {
+		return nil, nil
+	}
+
+	return &Place{
+		ID:     places[0].ID,
+		Name:   places[0].Name,
+		Kind:   places[0].Kind,
+		Center: GeoPoint{Lat: places[0].Lat, Long: places[0].Long},
+	}, nil
+}
+
+// applyPlace resolves the place mentioned in the query and sets it on the filter. An area restricts the
+// search to its polygon and drops the distance limit unless one was asked for; a landmark becomes the
+// location distances are measured from.
+func (h *Handler) applyPlace(ctx context.Context, filter *SearchFilter, name string, explicitDistance bool) (interface{}, error) {
+	place, err := h.pg.ResolvePlace(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if place == nil {
+		return map[string]interface{}{"place": name, "resolved": false}, nil
+	}
+
+	switch place.Kind {
+	case PlaceArea:
+		filter.AreaID = place.ID
+		filter.Place = place.Name
+		if filter.Location == nil {
+			filter.Location = &place.Center
+		}
+		if !explicitDistance {
+			filter.MaxDistance = 0
+		}
+	default:
+		filter.AreaID = 0
+		filter.Place = place.Name
+		filter.Location = &place.Center
+		if !explicitDistance {
+			filter.MaxDistance = DefaultLandmarkDistance
+		}
+	}
+
+	return map[string]interface{}{"place": place, "resolved": true}, nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/imkonsowa/restaurants-rag/lang"
+	"github.com/imkonsowa/restaurants-rag/models"
+)
+
+const DefaultSummaryAttempts = 2
+
+// GroundedSummary is the structured answer of the summary model, every restaurant and item refers to a search
+// result by id so that it can be verified before it reaches the user.
+type GroundedSummary struct {
+	Intro       string               `json:"intro"`
+	Restaurants []GroundedRestaurant `json:"restaurants"`
+}
+
+type GroundedRestaurant struct {
+	ID      uint64         `json:"id"`
+	Name    string         `json:"name"`
+	Comment string         `json:"comment"`
+	Items   []GroundedItem `json:"items"`
+}
+
+type GroundedItem struct {
+	ID    uint64  `json:"id"`
+	Name  string  `json:"name"`
+	Price float64 `json:"price"`
+}
+
+// Grounding tells the client how the summary was checked against the results.
+type Grounding struct {
+	Verified bool     `json:"verified"`         // the model answer passed verification
+	Template bool     `json:"template"`         // the answer was built from the results without the model
+	Attempts int      `json:"attempts"`         // model answers generated
+	Issues   []string `json:"issues,omitempty"` // verification failures of the last model answer
+}
+
+// pricePattern finds prices in free text: amounts with a currency or with two decimals.
+var pricePattern = regexp.MustCompile(`(?i)(?:\b(?:aed|egp|le)|\$)\s*(\d+(?:\.\d+)?)|(\d+(?:\.\d+)?)\s*(?:(?:aed|egp|le)\b|جنيه)|\b(\d+\.\d{2})\b`)
+
+// GenerateGroundedSummary asks the model for a structured summary of the results and verifies every name and
+// price it mentions. Answers failing verification are regenerated with the issues attached, and after the last
+// attempt the answer is built from the results by a template. Only ctx errors are returned.
+func (h *Handler) GenerateGroundedSummary(
+	ctx context.Context,
+	sessionID string,
+	userInput string,
+	results []SearchResult,
+) (string, *Grounding, error) {
+	attempts := h.cfg.Summary.Attempts
+	if attempts < 1 {
+		attempts = DefaultSummaryAttempts
+	}
+
+	currency := h.cfg.Summary.Currency
+	prompt := groundedSummaryPrompt(userInput, currency, results)
+	grounding := &Grounding{}
+
+	var answer string
+	for grounding.Attempts < attempts && answer == "" {
+		grounding.Attempts++
+
+		attemptPrompt := prompt
+		if len(grounding.Issues) > 0 {
+			attemptPrompt += "\nYour previous answer was rejected because:\n- " + strings.Join(grounding.Issues, "\n- ") +
+				"\nUse only the ids, names and prices listed above."
+		}
+
+		var summary GroundedSummary
+		if err := h.generateJSON(ctx, h.agentLLM, GroundedSummarySysPrompt, attemptPrompt, &summary); err != nil {
+			if ctx.Err() != nil {
+				return "", nil, ctx.Err()
+			}
+			slog.Warn("failed to generate grounded summary", "attempt", grounding.Attempts, "error", err)
+			grounding.Issues = []string{err.Error()}
+			continue
+		}
+
+		grounding.Issues = verifySummary(&summary, results)
+		if len(grounding.Issues) == 0 {
+			grounding.Verified = true
+			answer = renderSummary(&summary, currency, results)
+		}
+	}
+
+	if answer == "" {
+		slog.Warn("grounded summary failed verification, using the template", "issues", grounding.Issues)
+		grounding.Template = true
+		answer = renderSummary(templateSummary(userInput, results), currency, results)
+	}
+
+	if err := h.sessions.Memory(sessionID).SaveContext(ctx,
+		map[string]any{"input": prompt},
+		map[string]any{"text": answer},
+	); err != nil {
+		slog.Warn("failed to save summary to session memory", "session", sessionID, "error", err)
+	}
+
+	return answer, grounding, nil
+}
+
+func groundedSummaryPrompt(userInput, currency string, results []SearchResult) string {
+	var prompt strings.Builder
+
+	prompt.WriteString("User query: " + userInput + "\n")
+	prompt.WriteString("Prices are in " + currency + ".\n")
+	if language := lang.Detect(userInput); language != lang.English {
+		prompt.WriteString("Write intro and comments in " + language.Name() + ".\n")
+	}
+	prompt.WriteString("Search results:\n")
+	for _, r := range results {
+		prompt.WriteString(fmt.Sprintf("Restaurant id %d: %s\n", r.Restaurant.ID, r.Restaurant.Stringify()))
+		for _, item := range r.MenuItems {
+			prompt.WriteString(fmt.Sprintf("\tItem id %d: %s\n", item.ID, item.Stringify()))
+		}
+	}
+
+	return prompt.String()
+}
+
+// verifySummary checks the summary against the results and returns the problems found.
+func verifySummary(summary *GroundedSummary, results []SearchResult) []string {
+	byID := make(map[uint64]SearchResult, len(results))
+	prices := make(map[string]bool)
+	for _, r := range results {
+		byID[r.Restaurant.ID] = r
+		for _, item := range r.MenuItems {
+			prices[formatPrice(item.Price)] = true
+		}
+	}
+
+	var issues []string
+	if len(summary.Restaurants) == 0 {
+		issues = append(issues, "no restaurant was mentioned")
+	}
+
+	texts := []string{summary.Intro}
+	for _, gr := range summary.Restaurants {
+		texts = append(texts, gr.Comment)
+
+		result, ok := byID[gr.ID]
+		if !ok {
+			issues = append(issues, fmt.Sprintf("restaurant id %d is not in the results", gr.ID))
+			continue
+		}
+		if !sameName(gr.Name, result.Restaurant.Name, result.Restaurant.LocalName) {
+			issues = append(issues, fmt.Sprintf("restaurant id %d is %q, not %q", gr.ID, result.Restaurant.Name, gr.Name))
+		}
+
+		for _, gi := range gr.Items {
+			item, ok := findItem(result, gi.ID)
+			if !ok {
+				issues = append(issues, fmt.Sprintf("item id %d is not in the results of %s", gi.ID, result.Restaurant.Name))
+				continue
+			}
+			if !sameName(gi.Name, item.Name, item.LocalName) {
+				issues = append(issues, fmt.Sprintf("item id %d is %q, not %q", gi.ID, item.Name, gi.Name))
+			}
+			if math.Abs(gi.Price-item.Price) >= 0.005 {
+				issues = append(issues, fmt.Sprintf("%s costs %s, not %s", item.Name, formatPrice(item.Price), formatPrice(gi.Price)))
+			}
+		}
+	}
+
+	for _, text := range texts {
+		for _, m := range pricePattern.FindAllStringSubmatch(lang.Normalize(text), -1) {
+			for _, amount := range m[1:] {
+				if amount == "" {
+					continue
+				}
+				price, _ := strconv.ParseFloat(amount, 64)
+				if !prices[formatPrice(price)] {
+					issues = append(issues, fmt.Sprintf("price %s is not in the results", amount))
+				}
+			}
+		}
+	}
+
+	return issues
+}
+
+func findItem(result SearchResult, id uint64) (models.MenuItem, bool) {
+	for _, item := range result.MenuItems {
+		if item.ID == id {
+			return item, true
+		}
+	}
+
+	return models.MenuItem{}, false
+}
+
+// sameName accepts the name or the local name, ignoring case, spacing and Arabic spelling variants.
+func sameName(mentioned string, names ...string) bool {
+	mentioned = normalizeName(mentioned)
+	if mentioned == "" {
+		return false
+	}
+	for _, name := range names {
+		if name != "" && normalizeName(name) == mentioned {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(lang.Normalize(name))), " ")
+}
+
+func formatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', 2, 64)
+}
+
+// renderSummary writes the answer from a verified summary. Names and prices are taken from the results, the
+// model only contributes the intro and the comments.
+func renderSummary(summary *GroundedSummary, currency string, results []SearchResult) string {
+	byID := make(map[uint64]SearchResult, len(results))
+	for _, r := range results {
+		byID[r.Restaurant.ID] = r
+	}
+
+	var answer strings.Builder
+	if intro := strings.TrimSpace(summary.Intro); intro != "" {
+		answer.WriteString(intro + "\n\n")
+	}
+
+	for _, gr := range summary.Restaurants {
+		result := byID[gr.ID]
+		answer.WriteString(fmt.Sprintf("**%s** - %s - Rating: %.1f\n", result.Restaurant.Name, result.Restaurant.Area, result.Restaurant.Rating))
+		if comment := strings.TrimSpace(gr.Comment); comment != "" {
+			answer.WriteString(comment + "\n")
+		}
+
+		for _, gi := range gr.Items {
+			item, _ := findItem(result, gi.ID)
+			answer.WriteString(fmt.Sprintf("- %s: %s %s\n", item.Name, currency, formatPrice(item.Price)))
+		}
+		answer.WriteString("\n")
+	}
+
+	return strings.TrimSpace(answer.String())
+}
+
+// templateIntros introduce the template summary in the language of the query.
+var templateIntros = map[lang.Language]string{
+	lang.English: "Here are the restaurants I found for %q:",
+	lang.Arabic:  "إليك المطاعم التي وجدتها لـ %q:",
+	lang.Franco:  "Dol el mata3em elly la2etha le %q:",
+}
+
+// templateSummary lists the results without the model.
+func templateSummary(userInput string, results []SearchResult) *GroundedSummary {
+	summary := &GroundedSummary{
+		Intro: fmt.Sprintf(templateIntros[lang.Detect(userInput)], strings.TrimSpace(userInput)),
+	}
+	for _, r := range results {
+		gr := GroundedRestaurant{ID: r.Restaurant.ID, Name: r.Restaurant.Name}
+		for _, item := range r.MenuItems {
+			gr.Items = append(gr.Items, GroundedItem{ID: item.ID, Name: item.Name, Price: item.Price})
+		}
+		summary.Restaurants = append(summary.Restaurants, gr)
+	}
+
+	return summary
+}
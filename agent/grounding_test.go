@@ -0,0 +1,150 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/imkonsowa/restaurants-rag/models"
+)
+
+func groundingResults() []SearchResult {
+	pizza := result(1, "Pizza Place")
+	pizza.Restaurant.LocalName = "بيتزا بليس"
+	pizza.Restaurant.Area = "Marina"
+	pizza.Restaurant.Rating = 4.5
+	pizza.MenuItems = []models.MenuItem{
+		{ID: 10, Name: "Margherita", Price: 35},
+		{ID: 11, Name: "Pepperoni", LocalName: "بيبروني", Price: 42.5},
+	}
+
+	sushi := result(2, "Sushi Bar")
+	sushi.MenuItems = []models.MenuItem{{ID: 20, Name: "Salmon Roll", Price: 28}}
+
+	return []SearchResult{pizza, sushi}
+}
+
+func TestVerifySummary(t *testing.T) {
+	tests := []struct {
+		name       string
+		summary    GroundedSummary
+		wantIssues int
+	}{
+		{
+			name: "grounded",
+			summary: GroundedSummary{
+				Intro: "Two good options, pizza from AED 35.",
+				Restaurants: []GroundedRestaurant{
+					{ID: 1, Name: "pizza  place", Comment: "The pepperoni is 42.50 aed.", Items: []GroundedItem{{ID: 11, Name: "Pepperoni", Price: 42.5}}},
+					{ID: 2, Name: "Sushi Bar", Items: []GroundedItem{{ID: 20, Name: "salmon roll", Price: 28}}},
+				},
+			},
+		},
+		{
+			name: "local names",
+			summary: GroundedSummary{Restaurants: []GroundedRestaurant{
+				{ID: 1, Name: "بيتزا بليس", Items: []GroundedItem{{ID: 11, Name: "بيبروني", Price: 42.5}}},
+			}},
+		},
+		{
+			name:       "no restaurants",
+			summary:    GroundedSummary{Intro: "Nothing matched."},
+			wantIssues: 1,
+		},
+		{
+			name:       "unknown restaurant",
+			summary:    GroundedSummary{Restaurants: []GroundedRestaurant{{ID: 3, Name: "Burger Joint"}}},
+			wantIssues: 1,
+		},
+		{
+			name:       "wrong restaurant name",
+			summary:    GroundedSummary{Restaurants: []GroundedRestaurant{{ID: 1, Name: "Sushi Bar"}}},
+			wantIssues: 1,
+		},
+		{
+			name: "item of another restaurant",
+			summary: GroundedSummary{Restaurants: []GroundedRestaurant{
+				{ID: 1, Name: "Pizza Place", Items: []GroundedItem{{ID: 20, Name: "Salmon Roll", Price: 28}}},
+			}},
+			wantIssues: 1,
+		},
+		{
+			name: "wrong item name and price",
+			summary: GroundedSummary{Restaurants: []GroundedRestaurant{
+				{ID: 1, Name: "Pizza Place", Items: []GroundedItem{{ID: 10, Name: "Marinara", Price: 30}}},
+			}},
+			wantIssues: 2,
+		},
+		{
+			name: "invented prices in text",
+			summary: GroundedSummary{
+				Intro:       "Meals from $15.",
+				Restaurants: []GroundedRestaurant{{ID: 2, Name: "Sushi Bar", Comment: "Rolls are ٢٠ جنيه."}},
+			},
+			wantIssues: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := verifySummary(&tt.summary, groundingResults())
+			if len(issues) != tt.wantIssues {
+				t.Errorf("got issues %q, want %d", issues, tt.wantIssues)
+			}
+		})
+	}
+}
+
+func TestTemplateSummary(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantIntro string
+	}{
+		{"pizza near me", `Here are the restaurants I found for "pizza near me":`},
+		{" عايز بيتزا ", `إليك المطاعم التي وجدتها لـ "عايز بيتزا":`},
+		{"3ayez pizza", `Dol el mata3em elly la2etha le "3ayez pizza":`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			results := groundingResults()
+			summary := templateSummary(tt.input, results)
+
+			if summary.Intro != tt.wantIntro {
+				t.Errorf("intro = %q, want %q", summary.Intro, tt.wantIntro)
+			}
+			if issues := verifySummary(summary, results); len(issues) > 0 {
+				t.Errorf("template summary failed verification: %q", issues)
+			}
+
+			want := []GroundedRestaurant{
+				{ID: 1, Name: "Pizza Place", Items: []GroundedItem{{ID: 10, Name: "Margherita", Price: 35}, {ID: 11, Name: "Pepperoni", Price: 42.5}}},
+				{ID: 2, Name: "Sushi Bar", Items: []GroundedItem{{ID: 20, Name: "Salmon Roll", Price: 28}}},
+			}
+			if !reflect.DeepEqual(summary.Restaurants, want) {
+				t.Errorf("restaurants = %+v, want %+v", summary.Restaurants, want)
+			}
+		})
+	}
+}
+
+func TestRenderSummary(t *testing.T) {
+	summary := &GroundedSummary{
+		Intro: " Try these. ",
+		Restaurants: []GroundedRestaurant{
+			{ID: 1, Name: "pizza place", Comment: "Great crust.", Items: []GroundedItem{{ID: 11, Name: "pepperoni", Price: 42.5}}},
+		},
+	}
+
+	got := renderSummary(summary, "EGP", groundingResults())
+	want := strings.Join([]string{
+		"Try these.",
+		"",
+		"**Pizza Place** - Marina - Rating: 4.5",
+		"Great crust.",
+		"- Pepperoni: EGP 42.50",
+	}, "\n")
+	if got != want {
+		t.Errorf("renderSummary() = %q, want %q", got, want)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"log/slog"
 	"strings"
 
+	"github.com/imkonsowa/restaurants-rag/config"
+	"github.com/imkonsowa/restaurants-rag/lang"
 	"github.com/imkonsowa/restaurants-rag/models"
 	_ "github.com/lib/pq"
 	"github.com/tmc/langchaingo/chains"
@@ -16,25 +18,61 @@ import (
 )
 
 type ParsedInput struct {
-	Query      string   `json:"query"`      // cleaned query for semantic search
-	Distance   *float64 `json:"distance"`   // in meters, nil if not specified
-	Rating     *float64 `json:"rating"`     // 1-5 scale, nil if not specified
-	Confidence float64  `json:"confidence"` // 0-1 scale for parsing confidence
+	Query      string    `json:"query"`      // cleaned query for semantic search
+	Distance   *float64  `json:"distance"`   // in meters, nil if not specified
+	Rating     *float64  `json:"rating"`     // 1-5 scale, nil if not specified
+	MinPrice   *float64  `json:"min_price"`  // nil if not specified
+	MaxPrice   *float64  `json:"max_price"`  // nil if not specified
+	PriceTier  string    `json:"price_tier"` // $, $$ or $$$, empty if not specified
+	Sort       SortOrder `json:"sort"`       // empty keeps the relevance order
+	Badges     []string  `json:"badges"`     // required badges and dietary attributes
+	OpenNow    bool      `json:"open_now"`   // only restaurants open at the time of the search
+	OpenAt     string    `json:"open_at"`    // HH:MM local time the restaurant must be open at, empty if not specified
+	Place      string    `json:"place"`      // area or landmark mentioned in the query, empty if none
+	Confidence float64   `json:"confidence"` // 0-1 scale for parsing confidence
+
+	Paraphrases []string `json:"paraphrases,omitempty"` // alternative phrasings for multi-query expansion
+
+	Sources  map[string]string `json:"sources,omitempty"`  // parser that produced each field, rules or llm
+	Language lang.Language     `json:"language,omitempty"` // detected language of the input
 }
 
 type Handler struct {
-	contextLLM   *chains.LLMChain
+	cfg          *config.Config
+	sessions     *SessionStore
 	embeddingLLM *ollama.LLM
 	parserLLM    *ollama.LLM
+	agentLLM     *ollama.LLM
 	pg           *Pg
+	badges       *BadgeNormalizer
+	embeddings   *EmbeddingStore
+	cursors      *CursorCodec
+	cache        *SearchCache // nil when the search cache is disabled
 }
 
-func NewHandler(db *Pg, contextLLM *chains.LLMChain, embeddingLLM, parserLLM *ollama.LLM) (*Handler, error) {
+func NewHandler(
+	cfg *config.Config,
+	db *Pg,
+	sessions *SessionStore,
+	cache *SearchCache,
+	embeddingLLM, parserLLM, agentLLM *ollama.LLM,
+) (*Handler, error) {
+	cursors, err := NewCursorCodec(cfg.Search.CursorSecret)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Handler{
-		contextLLM:   contextLLM,
+		cfg:          cfg,
+		sessions:     sessions,
 		embeddingLLM: embeddingLLM,
 		parserLLM:    parserLLM,
+		agentLLM:     agentLLM,
 		pg:           db,
+		badges:       NewBadgeNormalizer(cfg.Badges.Synonyms),
+		embeddings:   NewEmbeddingStore(cfg.Search.CursorTTL, cfg.Search.CursorEmbeddings),
+		cursors:      cursors,
+		cache:        cache,
 	}, nil
 }
 
@@ -58,11 +96,13 @@ func (h *Handler) ListRestaurants(ctx context.Context) ([]models.Restaurant, err
 	return restaurants, nil
 }
 
-func (h *Handler) SearchByUserQuery(
-	ctx context.Context,
-	userInput string,
-	location *GeoPoint,
-) chan *ProcessingResult {
+// SearchByUserQuery runs the search pipeline and streams its events on the returned channel. The channel is
+// closed when the pipeline finishes; a result with io.EOF marks a successful end.
+func (h *Handler) SearchByUserQuery(ctx context.Context, req SearchRequest) chan *ProcessingResult {
+	if req.Options.Agent {
+		return h.AgentQuery(ctx, req)
+	}
+
 	resultChan := make(chan *ProcessingResult)
 
 	go func() {
@@ -73,149 +113,345 @@ func (h *Handler) SearchByUserQuery(
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		parsed, err := h.Parse(ctx, userInput)
-		if err != nil {
-			resultChan <- &ProcessingResult{
-				Err: fmt.Errorf("failed to parse user input: %w", err),
+		// send stops the pipeline when the consumer is gone instead of blocking forever.
+		send := func(result *ProcessingResult) bool {
+			select {
+			case resultChan <- result:
+				return true
+			case <-ctx.Done():
+				return false
 			}
-
-			return
 		}
-
-		resultChan <- &ProcessingResult{
-			Msg: WebSocketsMessage{
-				Type: "debug",
-				Data: parsed,
-			},
+		sendEvent := func(eventType EventType, data interface{}) bool {
+			return send(&ProcessingResult{Msg: Event{Type: eventType, Data: data}})
 		}
-
-		filter := SearchFilter{
-			MaxDistance: DefaultMaxDistance,
-			MinRating:   DefaultMinRating,
-			Location:    location,
+		sendErr := func(err error) {
+			send(&ProcessingResult{Err: err})
 		}
 
-		if parsed.Distance != nil {
-			filter.MaxDistance = *parsed.Distance
-		}
-		if parsed.Rating != nil {
-			filter.MinRating = *parsed.Rating
+		userInput := req.Input
+
+		var (
+			plan     *searchPlan
+			page     *SearchPage
+			cached   bool
+			cacheKey string
+			err      error
+		)
+		if h.cacheable(req) {
+			cacheKey = h.cache.Key(req)
+			plan, page, cached = h.cachedSearch(ctx, cacheKey, req)
 		}
-
-		queryVector, err := h.embeddingLLM.CreateEmbedding(ctx, []string{userInput})
-		if err != nil {
-			resultChan <- &ProcessingResult{
-				Err: fmt.Errorf("failed to generate query embedding: %w", err),
+		if !cached {
+			if req.State != nil && req.State.HasSearch() {
+				plan, err = h.planRefinement(ctx, req)
+			} else {
+				plan, err = h.planSearch(ctx, req)
 			}
+			if err != nil {
+				sendErr(withCode(CodeParserFailed, err))
+				return
+			}
+		}
 
+		if plan.Parsed != nil && !sendEvent(EventParsed, plan.Parsed) {
 			return
 		}
-		if len(queryVector) == 0 {
-			resultChan <- &ProcessingResult{
-				Msg: WebSocketsMessage{
-					Type: "chat",
-					Data: "I couldn't understand your query.",
-				},
+		for _, debug := range plan.Debug {
+			if !sendEvent(EventDebug, debug) {
+				return
 			}
-
-			return
 		}
 
-		results, err := h.pg.Search(ctx, queryVector[0], filter)
-		if err != nil {
-			slog.Error("failed to search restaurants in db", "error", err)
-
-			resultChan <- &ProcessingResult{
-				Err: fmt.Errorf("search failed: %w", err),
+		if !cached {
+			var queryVectors [][]float32
+			page, queryVectors, err = h.runSearch(ctx, userInput, plan, sendEvent)
+			if err != nil {
+				sendErr(err)
+				return
+			}
+			if page == nil {
+				send(&ProcessingResult{Err: io.EOF})
+				return
 			}
 
-			return
+			// Open now results change with the clock, they are not worth caching.
+			if cacheKey != "" && !plan.Filter.OpenNow && len(page.Results) > 0 {
+				h.cache.Put(cacheKey, *plan, queryVectors, page)
+			}
 		}
 
+		results := page.Results
+		req.State.Update(plan.Filter, plan.Queries, results)
 		if len(results) == 0 {
-			resultChan <- &ProcessingResult{
-				Msg: WebSocketsMessage{
-					Type: "chat",
-					Data: "I couldn't find any restaurants matching your criteria.",
-				},
+			if sendEvent(EventChat, "I couldn't find any restaurants matching your criteria.") {
+				send(&ProcessingResult{Err: io.EOF})
 			}
+			return
+		}
 
+		if !sendEvent(EventRestaurants, page) {
 			return
 		}
 
-		res, err := json.Marshal(map[string]interface{}{
-			"results": results,
+		if req.Summary && h.cfg.Summary.Grounded {
+			answer, grounding, err := h.GenerateGroundedSummary(ctx, req.SessionID, userInput, results)
+			if err != nil {
+				sendErr(withCode(CodeLLMFailed, fmt.Errorf("response generation failed: %w", err)))
+				return
+			}
+			if !sendEvent(EventChat, answer) || !sendEvent(EventGrounding, grounding) {
+				return
+			}
+		} else if req.Summary {
+			_, err = h.GenerateSummary(ctx, req.SessionID, userInput, results, func(message []byte) error {
+				if !sendEvent(EventChat, string(message)) {
+					return ctx.Err()
+				}
+
+				return nil
+			}, func(message []byte) error {
+				if !sendEvent(EventThinking, string(message)) {
+					return ctx.Err()
+				}
+
+				return nil
+			})
+			if err != nil {
+				sendErr(withCode(CodeLLMFailed, fmt.Errorf("response generation failed: %w", err)))
+				return
+			}
+		}
+
+		send(&ProcessingResult{
+			Err: io.EOF,
 		})
+	}()
+
+	return resultChan
+}
+
+// runSearch embeds the plan queries, searches and reranks the results into the first page. It returns a nil
+// page when the pipeline ended after sending its own event or the consumer is gone.
+func (h *Handler) runSearch(
+	ctx context.Context,
+	userInput string,
+	plan *searchPlan,
+	sendEvent func(eventType EventType, data interface{}) bool,
+) (*SearchPage, [][]float32, error) {
+	filter := plan.Filter
+	queries := plan.Queries
+
+	// One result past the page size tells whether a next page exists.
+	topK := filter.Limit
+	filter.Limit = topK + 1
+	rerank := h.cfg.Rerank.Enabled && (filter.Sort == "" || filter.Sort == SortByRelevance)
+	if rerank {
+		filter.Limit = max(h.rerankCandidates(), topK+1)
+	}
+
+	queryVectors, err := h.embedQueries(ctx, queries)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(queryVectors) == 0 {
+		sendEvent(EventChat, "I couldn't understand your query.")
+		return nil, nil, nil
+	}
+
+	results, err := h.pg.Search(ctx, queries[0], queryVectors, filter)
+	if err != nil {
+		slog.Error("failed to search restaurants in db", "error", err)
+
+		return nil, nil, withCode(CodeSearchFailed, fmt.Errorf("search failed: %w", err))
+	}
+
+	if rerank && len(results) > 0 {
+		reranked, scores, err := h.Rerank(ctx, userInput, results)
 		if err != nil {
-			resultChan <- &ProcessingResult{
-				Err: fmt.Errorf("failed to marshal results: %w", err),
+			slog.Warn("failed to rerank search results, keeping search order", "error", err)
+		} else {
+			results = reranked
+			if !sendEvent(EventDebug, map[string]interface{}{"rerank": scores}) {
+				return nil, nil, nil
 			}
-
-			return
 		}
+	}
 
-		resultChan <- &ProcessingResult{
-			Msg: WebSocketsMessage{
-				Type: "restaurants",
-				Data: string(res),
-			},
-		}
+	pageFilter := filter
+	pageFilter.Limit = topK
+	page, err := h.newSearchPage(results, topK, pageFilter, queries, "", queryVectors)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		_, err = h.GenerateSummary(ctx, userInput, results, func(message []byte) error {
-			resultChan <- &ProcessingResult{
-				Err: nil,
-				Msg: WebSocketsMessage{
-					Type: "chat",
-					Data: string(message),
-				},
-			}
+	return page, queryVectors, nil
+}
 
-			return nil
-		})
+// embedQueries embeds the search queries with the Arabic normalisation applied to the documents.
+func (h *Handler) embedQueries(ctx context.Context, queries []string) ([][]float32, error) {
+	normalized := make([]string, len(queries))
+	for i, query := range queries {
+		normalized[i] = lang.Normalize(query)
+	}
+
+	queryVectors, err := h.embeddingLLM.CreateEmbedding(ctx, normalized)
+	if err != nil {
+		return nil, withCode(CodeEmbeddingUnavailable, fmt.Errorf("failed to generate query embedding: %w", err))
+	}
+
+	return queryVectors, nil
+}
+
+// searchPlan is a search ready to run: the filter, the texts to embed, the parse they come from and the debug
+// events explaining them.
+type searchPlan struct {
+	Filter  SearchFilter
+	Queries []string
+	Parsed  interface{} // *ParsedInput for new searches, *FilterDelta for refinements
+	Debug   []interface{}
+}
+
+func (h *Handler) defaultFilter(location *GeoPoint) SearchFilter {
+	return SearchFilter{
+		MaxDistance:         DefaultMaxDistance,
+		MinRating:           DefaultMinRating,
+		Location:            location,
+		SimilarityThreshold: h.cfg.Search.SimilarityThreshold,
+		Limit:               h.cfg.Search.TopK,
+		ItemsPerRestaurant:  h.cfg.Search.ItemsPerRestaurant,
+	}
+}
+
+// planSearch parses the user input into a new search.
+func (h *Handler) planSearch(ctx context.Context, req SearchRequest) (*searchPlan, error) {
+	parsed, err := h.Parse(ctx, req.Input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse user input: %w", err)
+	}
+
+	filter := h.defaultFilter(req.Location)
+
+	if parsed.Distance != nil {
+		filter.MaxDistance = *parsed.Distance
+	}
+	if parsed.Rating != nil {
+		filter.MinRating = *parsed.Rating
+	}
+	filter.MinPrice = parsed.MinPrice
+	filter.MaxPrice = parsed.MaxPrice
+	filter.PriceRange = parsed.PriceTier
+	filter.Badges = h.badges.Normalize(parsed.Badges)
+	filter.Sort = parsed.Sort
+	filter.OpenNow = parsed.OpenNow
+	filter.OpenAt = parsed.OpenAt
+
+	var debug []interface{}
+	if parsed.Place != "" {
+		placeDebug, err := h.applyPlace(ctx, &filter, parsed.Place, parsed.Distance != nil)
 		if err != nil {
-			resultChan <- &ProcessingResult{
-				Err: fmt.Errorf("response generation failed: %w", err),
-			}
-			return
+			return nil, err
 		}
+		debug = append(debug, placeDebug)
+	}
 
-		resultChan <- &ProcessingResult{
-			Err: io.EOF,
+	req.Options.Apply(&filter)
+
+	queries := h.searchQueries(req.Input, parsed)
+
+	return &searchPlan{
+		Filter:  filter,
+		Queries: queries,
+		Parsed:  parsed,
+		Debug:   append(debug, map[string]interface{}{"search_queries": queries}),
+	}, nil
+}
+
+// searchQueries returns the texts to embed for the search, the first one being the primary query.
+// The parser's cleaned query is embedded, the raw input only when neither parser left a query.
+func (h *Handler) searchQueries(userInput string, parsed *ParsedInput) []string {
+	query := strings.TrimSpace(parsed.Query)
+	if query == "" {
+		query = userInput
+	}
+
+	queries := []string{query}
+	if !h.cfg.Retrieval.QueryExpansion {
+		return queries
+	}
+
+	seen := map[string]bool{strings.ToLower(query): true}
+	for _, paraphrase := range parsed.Paraphrases {
+		if len(queries) > h.maxParaphrases() {
+			break
 		}
 
-		return
-	}()
+		paraphrase = strings.TrimSpace(paraphrase)
+		if paraphrase == "" || seen[strings.ToLower(paraphrase)] {
+			continue
+		}
+		seen[strings.ToLower(paraphrase)] = true
+		queries = append(queries, paraphrase)
+	}
 
-	return resultChan
+	return queries
 }
 
+func (h *Handler) maxParaphrases() int {
+	if h.cfg.Retrieval.MaxParaphrases < 1 {
+		return 3
+	}
+
+	return h.cfg.Retrieval.MaxParaphrases
+}
+
+// GenerateSummary streams the summary of the results. Reasoning blocks of the model go to thinkingHandler when
+// the config streams them and are dropped otherwise, the returned summary is the answer alone.
 func (h *Handler) GenerateSummary(
 	ctx context.Context,
+	sessionID string,
 	userInput string,
-	restaurants []models.RestaurantWithMenuItems,
+	restaurants []SearchResult,
 	streamHandler func(message []byte) error,
+	thinkingHandler func(message []byte) error,
 ) (string, error) {
 	summary := createRestaurantSummary(userInput, restaurants)
 
-	finalResponse, err := chains.Run(
+	var onThinking func(text string) error
+	if h.cfg.Summary.Thinking == ThinkingStream && thinkingHandler != nil {
+		onThinking = func(text string) error {
+			return thinkingHandler([]byte(text))
+		}
+	}
+	filter := NewThinkFilter(func(text string) error {
+		return streamHandler([]byte(text))
+	}, onThinking)
+
+	_, err := chains.Run(
 		ctx,
-		h.contextLLM,
+		h.sessions.Chain(sessionID),
 		summary,
 		chains.WithTemperature(0),
 		chains.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
-			return streamHandler(chunk)
+			return filter.Write(chunk)
 		}),
 	)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate response: %w", err)
 	}
+	if err := filter.Flush(); err != nil {
+		return "", err
+	}
 
-	return finalResponse, nil
+	return filter.Answer(), nil
 }
-func createRestaurantSummary(userInput string, restaurants []models.RestaurantWithMenuItems) string {
+func createRestaurantSummary(userInput string, restaurants []SearchResult) string {
 	var summary strings.Builder
 
 	summary.WriteString("The user asked me to find restaurants for them. with this prompt: " + userInput + "\n")
+	if language := lang.Detect(userInput); language != lang.English {
+		summary.WriteString("Write the summary in " + language.Name() + ", using the local names in parentheses when there are any.\n")
+	}
 	summary.WriteString("Here are the restaurants I found:\n")
 
 	for _, restaurant := range restaurants {
@@ -231,58 +467,75 @@ func createRestaurantSummary(userInput string, restaurants []models.RestaurantWi
 	return summary.String()
 }
 
-type WebSocketsMessage struct {
-	Type string      `json:"type"`
-	Data interface{} `json:"data"`
+// Parse extracts the search parameters of the user input. The rule based parser always runs, the parser model
+// fills in what the rules cannot find when it answers with enough confidence; otherwise the rules are used alone.
+func (h *Handler) Parse(ctx context.Context, input string) (*ParsedInput, error) {
+	parsed := ParseRules(lang.Normalize(input))
+	parsed.Language = lang.Detect(input)
+
+	llmParsed, err := h.parseLLM(ctx, input)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		slog.Warn("parser model failed, using the rule based parse", "error", err)
+		return parsed, nil
+	}
+	if llmParsed.Confidence < h.cfg.Retrieval.MinLLMConfidence {
+		slog.Info("parser model not confident enough, using the rule based parse", "confidence", llmParsed.Confidence)
+		return parsed, nil
+	}
+
+	parsed.mergeLLM(llmParsed, h.cfg.Retrieval.MinParseConfidence)
+
+	return parsed, nil
 }
 
-func (h *Handler) Parse(ctx context.Context, input string) (*ParsedInput, error) {
+func (h *Handler) parseLLM(ctx context.Context, input string) (*ParsedInput, error) {
 	prompt := fmt.Sprintf("Parse this search query and return only valid JSON: %q", input)
 
+	sysPrompt := ParserSysPrompt
+	if h.cfg.Retrieval.QueryExpansion {
+		sysPrompt += fmt.Sprintf(ParserExpansionPrompt, h.maxParaphrases())
+	}
+
+	var parsed ParsedInput
+	if err := h.generateJSON(ctx, h.parserLLM, sysPrompt, prompt, &parsed); err != nil {
+		return nil, err
+	}
+
+	if err := h.validateParsedInput(&parsed); err != nil {
+		return nil, fmt.Errorf("invalid parser model output: %w", err)
+	}
+
+	return &parsed, nil
+}
+
+// generateJSON runs a single JSON-mode completion on llm and decodes the answer into out.
+func (h *Handler) generateJSON(ctx context.Context, llm *ollama.LLM, sysPrompt, prompt string, out interface{}) error {
 	messages := []llms.MessageContent{
-		{
-			Role: llms.ChatMessageTypeSystem,
-			Parts: []llms.ContentPart{
-				//				llms.TextPart(`You are an assistant that responds with a must be valid JSON object contains distance key and rating key for restaurant.
-				//For "nearby" → set distance to 1000 (meters)
-				//For "highly rated" → set rating to 4.0
-				//ONLY output valid JSON, no other text
-				//If parameter not found, omit it from the json body
-				//`)
-				llms.TextPart(ParserSysPrompt),
-			},
-		},
-		{
-			Role: llms.ChatMessageTypeHuman,
-			Parts: []llms.ContentPart{
-				llms.TextPart(prompt),
-			},
-		},
-	}
-	content, err := h.parserLLM.GenerateContent(
+		llms.TextParts(llms.ChatMessageTypeSystem, sysPrompt),
+		llms.TextParts(llms.ChatMessageTypeHuman, prompt),
+	}
+
+	content, err := llm.GenerateContent(
 		ctx,
 		messages,
 		llms.WithJSONMode(),
 		llms.WithTemperature(0),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate content: %w", err)
+		return fmt.Errorf("failed to generate content: %w", err)
 	}
-
-	var parsed ParsedInput
-	if content.Choices == nil {
-		return &parsed, nil
+	if len(content.Choices) == 0 {
+		return fmt.Errorf("empty response from the model")
 	}
 
-	payload := content.Choices[0].Content
-	json.Unmarshal([]byte(payload), &parsed)
-
-	// Validate parsed output
-	if err := h.validateParsedInput(&parsed); err != nil {
-		return nil, err
+	if err := json.Unmarshal([]byte(stripThinking(content.Choices[0].Content)), out); err != nil {
+		return fmt.Errorf("failed to decode model response: %w", err)
 	}
 
-	return &parsed, nil
+	return nil
 }
 
 func (h *Handler) validateParsedInput(input *ParsedInput) error {
@@ -296,5 +549,29 @@ func (h *Handler) validateParsedInput(input *ParsedInput) error {
 		}
 	}
 
+	if input.MinPrice != nil && *input.MinPrice < 0 {
+		return fmt.Errorf("min price must not be negative")
+	}
+	if input.MaxPrice != nil && *input.MaxPrice <= 0 {
+		return fmt.Errorf("max price must be positive")
+	}
+	if input.MinPrice != nil && input.MaxPrice != nil && *input.MinPrice > *input.MaxPrice {
+		return fmt.Errorf("min price must not exceed max price")
+	}
+
+	if _, err := ParseSortOrder(string(input.Sort)); err != nil {
+		return err
+	}
+
+	switch input.PriceTier {
+	case "", PriceTierLow, PriceTierMid, PriceTierHigh:
+	default:
+		return fmt.Errorf("price tier must be one of $, $$ or $$$")
+	}
+
+	if input.OpenAt != "" && !validClock(input.OpenAt) {
+		return fmt.Errorf("open_at must be a HH:MM time")
+	}
+
 	return nil
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/imkonsowa/restaurants-rag/models"
+)
+
+const (
+	DefaultTimezone = "UTC"
+
+	clockLayout = "15:04"
+)
+
+// OpeningPeriod is one period of the weekly schedule, e.g. {"day": "friday", "opens": "18:00", "closes": "02:00"}.
+// A closing time at or before the opening time ends the next day.
+type OpeningPeriod struct {
+	Day    string `json:"day"`
+	Opens  string `json:"opens"`
+	Closes string `json:"closes"`
+}
+
+// OpeningException replaces the weekly schedule of a date, either closing the restaurant or changing its hours.
+type OpeningException struct {
+	Date   string `json:"date"`
+	Closed bool   `json:"closed"`
+	Opens  string `json:"opens"`
+	Closes string `json:"closes"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	day = strings.ToLower(strings.TrimSpace(day))
+	if weekday, ok := weekdays[day]; ok {
+		return weekday, nil
+	}
+	for name, weekday := range weekdays {
+		if len(day) >= 3 && strings.HasPrefix(name, day) {
+			return weekday, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid day %q", day)
+}
+
+// validClock reports whether the value is a HH:MM time of day.
+func validClock(value string) bool {
+	_, err := time.Parse(clockLayout, value)
+	return err == nil
+}
+
+func validateTimezone(tz string) error {
+	if tz == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return fmt.Errorf("invalid timezone %q", tz)
+	}
+
+	return nil
+}
+
+func (p OpeningPeriod) Validate() error {
+	if _, err := parseWeekday(p.Day); err != nil {
+		return err
+	}
+	if !validClock(p.Opens) || !validClock(p.Closes) {
+		return fmt.Errorf("opening hours must be HH:MM times")
+	}
+
+	return nil
+}
+
+func (e OpeningException) Validate() error {
+	if _, err := time.Parse(time.DateOnly, e.Date); err != nil {
+		return fmt.Errorf("invalid exception date %q, expected YYYY-MM-DD", e.Date)
+	}
+	if e.Closed {
+		return nil
+	}
+	if !validClock(e.Opens) || !validClock(e.Closes) {
+		return fmt.Errorf("exceptions that are not closed need HH:MM opening hours")
+	}
+
+	return nil
+}
+
+func (p OpeningPeriod) ToModel() models.OpeningHours {
+	day, _ := parseWeekday(p.Day)
+
+	return models.OpeningHours{
+		DayOfWeek: int(day),
+		Opens:     p.Opens,
+		Closes:    p.Closes,
+	}
+}
+
+func (e OpeningException) ToModel() models.OpeningHoursException {
+	exception := models.OpeningHoursException{
+		Date:   e.Date,
+		Closed: e.Closed,
+	}
+	if !e.Closed {
+		exception.Opens = &e.Opens
+		exception.Closes = &e.Closes
+	}
+
+	return exception
+}
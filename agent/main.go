@@ -1,21 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"io"
 	"log"
 	"log/slog"
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/imkonsowa/restaurants-rag/config"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/tmc/langchaingo/chains"
+	"github.com/nats-io/nats.go"
 	"github.com/tmc/langchaingo/llms/ollama"
-	"github.com/tmc/langchaingo/memory"
-	"github.com/tmc/langchaingo/memory/sqlite3"
 )
 
 type Agent struct {
@@ -32,19 +30,13 @@ func main() {
 		log.Fatal()
 	}
 
-	chatHistory := sqlite3.NewSqliteChatMessageHistory(
-		sqlite3.WithSession("restaurants-agent"),
-		sqlite3.WithDB(sqliteDb),
-	)
-	conversationBuffer := memory.NewConversationBuffer(memory.WithChatHistory(chatHistory))
-
-	db, err := NewRestaurantPg(cfg.Postgres.ConnStr())
+	db, err := NewRestaurantPg(cfg.Postgres.ConnStr(), cfg.Retrieval)
 	if err != nil {
 		log.Fatal(err)
 	}
 	embeddingLLM, err := ollama.New(
 		ollama.WithServerURL(cfg.Ollama.Address()),
-		ollama.WithModel(cfg.Ollama.EmbeddingModel),
+		ollama.WithModel(cfg.Ollama.Embedder()),
 	)
 	if err != nil {
 		log.Fatal(err)
@@ -68,9 +60,36 @@ func main() {
 		log.Fatal(err)
 	}
 
-	llmChain := chains.NewConversation(contextLLM, conversationBuffer)
+	// The agent model runs without the summary system prompt, its instructions come with the agent prompt.
+	agentLLM, err := ollama.New(
+		ollama.WithServerURL(cfg.Ollama.Address()),
+		ollama.WithModel(cfg.Ollama.ContextModel),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sessions := NewSessionStore(sqliteDb, contextLLM)
+	go sessions.RunRetention(context.Background(), cfg.Sessions.Retention, cfg.Sessions.PurgeInterval)
+
+	// Cached results are only safe while CDC events invalidate them, without NATS the cache stays off.
+	var cache *SearchCache
+	if cfg.Cache.Enabled {
+		nc, err := nats.Connect(cfg.Nats.ConnStr())
+		if err != nil {
+			slog.Warn("failed to connect to nats, search cache disabled", "error", err)
+		} else {
+			defer nc.Close()
+
+			cache = NewSearchCache(cfg.Cache)
+			if err := cache.SubscribeCDC(nc, cfg.Nats); err != nil {
+				slog.Warn("failed to subscribe to cdc events, search cache disabled", "error", err)
+				cache = nil
+			}
+		}
+	}
 
-	handler, err := NewHandler(db, &llmChain, embeddingLLM, parserLLM)
+	handler, err := NewHandler(cfg, db, sessions, cache, embeddingLLM, parserLLM, agentLLM)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -78,7 +97,7 @@ func main() {
 	agent := &Agent{
 		handler:  handler,
 		config:   cfg,
-		upgrader: websocket.Upgrader{},
+		upgrader: websocket.Upgrader{Subprotocols: []string{ProtocolV1}},
 	}
 
 	if err := agent.Run(); err != nil {
@@ -88,6 +107,7 @@ func main() {
 
 func (a *Agent) Run() error {
 	r := gin.Default()
+	r.Use(sessionMiddleware(sessionSecret(a.config.Sessions)))
 
 	r.StaticFile("/", "web/index.html")
 
@@ -95,6 +115,45 @@ func (a *Agent) Run() error {
 		input, _ := ctx.GetQuery("input")
 		longitude, _ := ctx.GetQuery("longitude")
 		latitude, _ := ctx.GetQuery("latitude")
+		cursor := ctx.Query("cursor")
+
+		var opts SearchOptions
+		if err := ctx.ShouldBindQuery(&opts); err != nil {
+			ctx.JSON(http.StatusBadRequest, errorBody(withCode(CodeInvalidRequest, err)))
+			return
+		}
+		if err := opts.Validate(); err != nil {
+			ctx.JSON(http.StatusBadRequest, errorBody(withCode(CodeInvalidRequest, err)))
+			return
+		}
+
+		// Requests are validated before the upgrade, where the error can still be a plain HTTP response.
+		var req *SearchRequest
+		switch {
+		case cursor != "":
+			if _, err := a.handler.cursors.Decode(cursor); err != nil {
+				ctx.JSON(http.StatusBadRequest, errorBody(err))
+				return
+			}
+		case input != "":
+			point, err := ParseGeoPoint(latitude, longitude)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, errorBody(err))
+				return
+			}
+
+			req = &SearchRequest{
+				Input:     input,
+				Location:  point,
+				Summary:   true,
+				Options:   opts,
+				SessionID: sessionFromContext(ctx),
+			}
+			if err := req.Validate(); err != nil {
+				ctx.JSON(http.StatusBadRequest, errorBody(err))
+				return
+			}
+		}
 
 		w, r := ctx.Writer, ctx.Request
 		c, err := a.upgrader.Upgrade(w, r, nil)
@@ -104,25 +163,31 @@ func (a *Agent) Run() error {
 		}
 		defer c.Close()
 
-		var point *GeoPoint
-
-		if longitude != "" && latitude != "" {
-			point = &GeoPoint{}
+		if c.Subprotocol() == ProtocolV1 {
+			a.serveV1(ctx, c)
+			return
+		}
 
-			point.Lat, err = strconv.ParseFloat(latitude, 64)
+		if cursor != "" {
+			page, err := a.handler.NextPage(ctx, cursor)
 			if err != nil {
-				ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid latitude"})
+				closeWithError(c, err)
 				return
 			}
 
-			point.Long, err = strconv.ParseFloat(longitude, 64)
-			if err != nil {
-				ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid longitude"})
-				return
+			if writeEvent(c, Event{Type: EventRestaurants, Data: page}) {
+				closeConn(c, websocket.CloseNormalClosure, "")
 			}
+			return
 		}
 
-		resultChan := a.handler.SearchByUserQuery(ctx, input, point)
+		// Without an input the connection stays open for a conversation of query messages.
+		if req == nil {
+			a.converse(ctx, c)
+			return
+		}
+
+		resultChan := a.handler.SearchByUserQuery(ctx.Request.Context(), *req)
 		for {
 			select {
 			case <-ctx.Request.Context().Done():
@@ -133,20 +198,180 @@ func (a *Agent) Run() error {
 				}
 				if result.Err != nil {
 					if result.Err == io.EOF {
-						return
+						closeConn(c, websocket.CloseNormalClosure, "")
+					} else {
+						closeWithError(c, result.Err)
 					}
-					ctx.JSON(http.StatusInternalServerError, gin.H{"error": result.Err.Error()})
 					return
 				}
 
-				if err := c.WriteJSON(result.Msg); err != nil {
-					slog.Error("failed to write to ws connection", "error", err)
+				if !writeEvent(c, result.Msg) {
 					return
 				}
 			}
 		}
 	})
 
+	r.POST("/api/search", func(context *gin.Context) {
+		var req SearchRequest
+		if err := context.ShouldBindJSON(&req); err != nil {
+			context.JSON(http.StatusBadRequest, errorBody(withCode(CodeInvalidRequest, err)))
+			return
+		}
+
+		if err := req.Validate(); err != nil {
+			context.JSON(http.StatusBadRequest, errorBody(err))
+			return
+		}
+
+		req.SessionID = sessionFromContext(context)
+
+		resp, err := a.handler.Search(context.Request.Context(), req)
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		context.JSON(http.StatusOK, resp)
+	})
+
+	r.GET("/api/search/stream", func(context *gin.Context) {
+		req := SearchRequest{
+			Input:     context.Query("input"),
+			Summary:   true,
+			SessionID: sessionFromContext(context),
+		}
+
+		if err := context.ShouldBindQuery(&req.Options); err != nil {
+			context.JSON(http.StatusBadRequest, errorBody(withCode(CodeInvalidRequest, err)))
+			return
+		}
+
+		point, err := ParseGeoPoint(context.Query("latitude"), context.Query("longitude"))
+		if err != nil {
+			context.JSON(http.StatusBadRequest, errorBody(err))
+			return
+		}
+		req.Location = point
+
+		if err := req.Validate(); err != nil {
+			context.JSON(http.StatusBadRequest, errorBody(err))
+			return
+		}
+
+		resultChan := a.handler.SearchByUserQuery(context.Request.Context(), req)
+		context.Stream(func(w io.Writer) bool {
+			result, ok := <-resultChan
+			if !ok {
+				return false
+			}
+			if result.Err != nil {
+				if result.Err != io.EOF {
+					context.SSEvent(string(EventError), errorBody(result.Err))
+				}
+				return false
+			}
+
+			context.SSEvent(string(result.Msg.Type), result.Msg.Data)
+			return true
+		})
+	})
+
+	r.POST("/api/search/map", func(context *gin.Context) {
+		var req MapSearchRequest
+		if err := context.ShouldBindJSON(&req); err != nil {
+			context.JSON(http.StatusBadRequest, errorBody(withCode(CodeInvalidRequest, err)))
+			return
+		}
+
+		if err := req.Validate(); err != nil {
+			context.JSON(http.StatusBadRequest, errorBody(withCode(CodeInvalidRequest, err)))
+			return
+		}
+
+		page, err := a.handler.MapSearch(context.Request.Context(), req)
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		context.JSON(http.StatusOK, page)
+	})
+
+	r.GET("/search/next", func(context *gin.Context) {
+		cursor := context.Query("cursor")
+		if cursor == "" {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "cursor is required"})
+			return
+		}
+
+		page, err := a.handler.NextPage(context, cursor)
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		context.JSON(http.StatusOK, page)
+	})
+
+	// Clients only see and delete their own session.
+	r.GET("/session", func(context *gin.Context) {
+		sessionID := sessionFromContext(context)
+		messages, err := a.handler.sessions.Messages(context, sessionID)
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		context.JSON(http.StatusOK, gin.H{"id": sessionID, "messages": messages})
+	})
+
+	r.DELETE("/session", func(context *gin.Context) {
+		if err := a.handler.sessions.Delete(context, sessionFromContext(context)); err != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		context.Status(http.StatusNoContent)
+	})
+
+	if token := a.config.Sessions.AdminToken; token != "" {
+		admin := r.Group("/sessions", adminAuth(token))
+
+		admin.GET("", func(context *gin.Context) {
+			sessions, err := a.handler.sessions.List(context)
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			context.JSON(http.StatusOK, sessions)
+		})
+
+		admin.GET("/:id", func(context *gin.Context) {
+			messages, err := a.handler.sessions.Messages(context, context.Param("id"))
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			context.JSON(http.StatusOK, gin.H{"id": context.Param("id"), "messages": messages})
+		})
+
+		admin.DELETE("/:id", func(context *gin.Context) {
+			if err := a.handler.sessions.Delete(context, context.Param("id")); err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			context.Status(http.StatusNoContent)
+		})
+	}
+
+	r.GET("/cache/stats", func(context *gin.Context) {
+		context.JSON(http.StatusOK, a.handler.cache.Stats())
+	})
+
 	r.POST("/restaurants", func(context *gin.Context) {
 		var restaurants CreateRestaurantsRequest
 
@@ -178,5 +403,184 @@ func (a *Agent) Run() error {
 		context.JSON(http.StatusOK, restaurants)
 	})
 
+	r.GET("/restaurants/:id", func(context *gin.Context) {
+		id, err := idParam(context, "id")
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		restaurant, err := a.handler.GetRestaurantWithMenu(context, id)
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		context.JSON(http.StatusOK, restaurant)
+	})
+
+	updateRestaurant := func(partial bool) gin.HandlerFunc {
+		return func(context *gin.Context) {
+			id, err := idParam(context, "id")
+			if err != nil {
+				context.JSON(httpStatus(err), errorBody(err))
+				return
+			}
+
+			var update RestaurantUpdate
+			if err := context.ShouldBindJSON(&update); err != nil {
+				context.JSON(http.StatusBadRequest, errorBody(withCode(CodeInvalidRequest, err)))
+				return
+			}
+
+			restaurant, err := a.handler.UpdateRestaurant(context, id, &update, partial)
+			if err != nil {
+				context.JSON(httpStatus(err), errorBody(err))
+				return
+			}
+
+			context.JSON(http.StatusOK, restaurant)
+		}
+	}
+	r.PUT("/restaurants/:id", updateRestaurant(false))
+	r.PATCH("/restaurants/:id", updateRestaurant(true))
+
+	r.DELETE("/restaurants/:id", func(context *gin.Context) {
+		id, err := idParam(context, "id")
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		version, err := versionQuery(context, "restaurant")
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		if err := a.handler.DeleteRestaurant(context, id, version); err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		context.Status(http.StatusNoContent)
+	})
+
+	r.GET("/restaurants/:id/menu-items", func(context *gin.Context) {
+		id, err := idParam(context, "id")
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		items, err := a.handler.ListMenuItems(context, id)
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		context.JSON(http.StatusOK, items)
+	})
+
+	r.POST("/restaurants/:id/menu-items", func(context *gin.Context) {
+		id, err := idParam(context, "id")
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		var create MenuItemUpdate
+		if err := context.ShouldBindJSON(&create); err != nil {
+			context.JSON(http.StatusBadRequest, errorBody(withCode(CodeInvalidRequest, err)))
+			return
+		}
+
+		item, err := a.handler.CreateMenuItem(context, id, &create)
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		context.JSON(http.StatusCreated, item)
+	})
+
+	r.GET("/restaurants/:id/menu-items/:item_id", func(context *gin.Context) {
+		id, err := idParam(context, "id")
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+		itemID, err := idParam(context, "item_id")
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		item, err := a.handler.GetMenuItem(context, id, itemID)
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		context.JSON(http.StatusOK, item)
+	})
+
+	updateMenuItem := func(partial bool) gin.HandlerFunc {
+		return func(context *gin.Context) {
+			id, err := idParam(context, "id")
+			if err != nil {
+				context.JSON(httpStatus(err), errorBody(err))
+				return
+			}
+			itemID, err := idParam(context, "item_id")
+			if err != nil {
+				context.JSON(httpStatus(err), errorBody(err))
+				return
+			}
+
+			var update MenuItemUpdate
+			if err := context.ShouldBindJSON(&update); err != nil {
+				context.JSON(http.StatusBadRequest, errorBody(withCode(CodeInvalidRequest, err)))
+				return
+			}
+
+			item, err := a.handler.UpdateMenuItem(context, id, itemID, &update, partial)
+			if err != nil {
+				context.JSON(httpStatus(err), errorBody(err))
+				return
+			}
+
+			context.JSON(http.StatusOK, item)
+		}
+	}
+	r.PUT("/restaurants/:id/menu-items/:item_id", updateMenuItem(false))
+	r.PATCH("/restaurants/:id/menu-items/:item_id", updateMenuItem(true))
+
+	r.DELETE("/restaurants/:id/menu-items/:item_id", func(context *gin.Context) {
+		id, err := idParam(context, "id")
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+		itemID, err := idParam(context, "item_id")
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		version, err := versionQuery(context, "menu item")
+		if err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		if err := a.handler.DeleteMenuItem(context, id, itemID, version); err != nil {
+			context.JSON(httpStatus(err), errorBody(err))
+			return
+		}
+
+		context.Status(http.StatusNoContent)
+	})
+
 	return r.Run(a.config.Server.Address())
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MapSearchRequest searches the restaurants inside a map shape, either a bounding box or a GeoJSON polygon.
+type MapSearchRequest struct {
+	Input    string          `json:"input"`    // optional, without it the restaurants in the shape are listed by rating
+	BBox     []float64       `json:"bbox"`     // [min_long, min_lat, max_long, max_lat]
+	Polygon  json.RawMessage `json:"polygon"`  // GeoJSON Polygon or MultiPolygon geometry
+	Location *GeoPoint       `json:"location"` // user location, used for distances only
+	Options  SearchOptions   `json:"options"`
+}
+
+func (r *MapSearchRequest) Validate() error {
+	if (len(r.BBox) == 0) == (len(r.Polygon) == 0) {
+		return fmt.Errorf("either bbox or polygon is required")
+	}
+
+	if len(r.BBox) > 0 {
+		if len(r.BBox) != 4 {
+			return fmt.Errorf("bbox must be [min_long, min_lat, max_long, max_lat]")
+		}
+		if err := validateCoordinate([]float64{r.BBox[0], r.BBox[1]}); err != nil {
+			return err
+		}
+		if err := validateCoordinate([]float64{r.BBox[2], r.BBox[3]}); err != nil {
+			return err
+		}
+		if r.BBox[0] >= r.BBox[2] || r.BBox[1] >= r.BBox[3] {
+			return fmt.Errorf("bbox minimums must be lower than its maximums")
+		}
+	} else if err := validatePolygon(r.Polygon); err != nil {
+		return err
+	}
+
+	if r.Location != nil {
+		if err := validateCoordinate([]float64{r.Location.Long, r.Location.Lat}); err != nil {
+			return err
+		}
+	}
+
+	return r.Options.Validate()
+}
+
+// Shape returns the search shape as a GeoJSON geometry in the usual longitude, latitude order.
+func (r *MapSearchRequest) Shape() string {
+	if len(r.Polygon) > 0 {
+		return string(r.Polygon)
+	}
+
+	minLong, minLat, maxLong, maxLat := r.BBox[0], r.BBox[1], r.BBox[2], r.BBox[3]
+
+	return fmt.Sprintf(
+		`{"type":"Polygon","coordinates":[[[%[1]f,%[2]f],[%[3]f,%[2]f],[%[3]f,%[4]f],[%[1]f,%[4]f],[%[1]f,%[2]f]]]}`,
+		minLong, minLat, maxLong, maxLat,
+	)
+}
+
+func validatePolygon(data json.RawMessage) error {
+	var geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if err := json.Unmarshal(data, &geometry); err != nil {
+		return fmt.Errorf("invalid polygon: %w", err)
+	}
+
+	var polygons [][][][]float64
+	switch geometry.Type {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &polygon); err != nil {
+			return fmt.Errorf("invalid polygon coordinates: %w", err)
+		}
+		polygons = append(polygons, polygon)
+	case "MultiPolygon":
+		if err := json.Unmarshal(geometry.Coordinates, &polygons); err != nil {
+			return fmt.Errorf("invalid polygon coordinates: %w", err)
+		}
+	default:
+		return fmt.Errorf("polygon must be a GeoJSON Polygon or MultiPolygon")
+	}
+
+	if len(polygons) == 0 {
+		return fmt.Errorf("polygon has no coordinates")
+	}
+	for _, polygon := range polygons {
+		if len(polygon) == 0 {
+			return fmt.Errorf("polygon has no rings")
+		}
+		for _, ring := range polygon {
+			if len(ring) < 4 {
+				return fmt.Errorf("polygon rings need at least 4 positions")
+			}
+			for _, position := range ring {
+				if err := validateCoordinate(position); err != nil {
+					return err
+				}
+			}
+			first, last := ring[0], ring[len(ring)-1]
+			if first[0] != last[0] || first[1] != last[1] {
+				return fmt.Errorf("polygon rings must be closed")
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateCoordinate checks a GeoJSON [longitude, latitude] position.
+func validateCoordinate(position []float64) error {
+	if len(position) < 2 {
+		return fmt.Errorf("positions must be [longitude, latitude]")
+	}
+	if position[0] < -180 || position[0] > 180 {
+		return fmt.Errorf("invalid longitude")
+	}
+	if position[1] < -90 || position[1] > 90 {
+		return fmt.Errorf("invalid latitude")
+	}
+
+	return nil
+}
+
+// MapSearch returns the restaurants inside the requested shape. With an input it runs the search pipeline
+// with the shape replacing the distance radius, otherwise it lists the restaurants in the shape.
+func (h *Handler) MapSearch(ctx context.Context, req MapSearchRequest) (*SearchPage, error) {
+	if strings.TrimSpace(req.Input) == "" {
+		filter := h.defaultFilter(req.Location)
+		filter.MaxDistance = 0
+		filter.MinRating = 0
+		filter.Bounds = req.Shape()
+		req.Options.Apply(&filter)
+
+		results, err := h.pg.ListInBounds(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		return &SearchPage{Results: results}, nil
+	}
+
+	plan, err := h.planSearch(ctx, SearchRequest{Input: req.Input, Location: req.Location, Options: req.Options})
+	if err != nil {
+		return nil, err
+	}
+
+	// The shape is the area of the search, it replaces places and the radius taken from the query.
+	filter := plan.Filter
+	filter.Bounds = req.Shape()
+	filter.AreaID = 0
+	filter.Place = ""
+	filter.Location = req.Location
+
+	queryVectors, err := h.embedQueries(ctx, plan.Queries)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+
+	results, err := h.pg.Search(ctx, plan.Queries[0], queryVectors, filter)
+	if err != nil {
+		return nil, withCode(CodeSearchFailed, fmt.Errorf("search failed: %w", err))
+	}
+
+	filter.Limit = pageSize
+
+	return h.newSearchPage(results, pageSize, filter, plan.Queries, "", queryVectors)
+}
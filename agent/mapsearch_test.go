@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const square = `[[[55.1,25.1],[55.2,25.1],[55.2,25.2],[55.1,25.2],[55.1,25.1]]]`
+
+func TestMapSearchRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		request MapSearchRequest
+		wantErr bool
+	}{
+		{"bbox", MapSearchRequest{BBox: []float64{55.1, 25.1, 55.2, 25.2}}, false},
+		{"polygon", MapSearchRequest{Polygon: json.RawMessage(`{"type":"Polygon","coordinates":` + square + `}`)}, false},
+		{"with location", MapSearchRequest{BBox: []float64{55.1, 25.1, 55.2, 25.2}, Location: &GeoPoint{Lat: 25.15, Long: 55.15}}, false},
+		{"no shape", MapSearchRequest{}, true},
+		{"both shapes", MapSearchRequest{BBox: []float64{55.1, 25.1, 55.2, 25.2}, Polygon: json.RawMessage(`{"type":"Polygon","coordinates":` + square + `}`)}, true},
+		{"short bbox", MapSearchRequest{BBox: []float64{55.1, 25.1, 55.2}}, true},
+		{"bbox out of range", MapSearchRequest{BBox: []float64{55.1, 25.1, 181, 25.2}}, true},
+		{"inverted bbox", MapSearchRequest{BBox: []float64{55.2, 25.1, 55.1, 25.2}}, true},
+		{"empty bbox", MapSearchRequest{BBox: []float64{55.1, 25.1, 55.1, 25.2}}, true},
+		{"invalid polygon", MapSearchRequest{Polygon: json.RawMessage(`{"type":"Point","coordinates":[55.1,25.1]}`)}, true},
+		{"invalid location", MapSearchRequest{BBox: []float64{55.1, 25.1, 55.2, 25.2}, Location: &GeoPoint{Lat: 95, Long: 55.15}}, true},
+		{"invalid options", MapSearchRequest{BBox: []float64{55.1, 25.1, 55.2, 25.2}, Options: SearchOptions{Mode: "fuzzy"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.request.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePolygon(t *testing.T) {
+	tests := []struct {
+		name     string
+		geometry string
+		wantErr  bool
+	}{
+		{"polygon", `{"type":"Polygon","coordinates":` + square + `}`, false},
+		{"polygon with a hole", `{"type":"Polygon","coordinates":[[[55,25],[56,25],[56,26],[55,26],[55,25]],[[55.4,25.4],[55.6,25.4],[55.6,25.6],[55.4,25.4]]]}`, false},
+		{"multipolygon", `{"type":"MultiPolygon","coordinates":[` + square + `,` + square + `]}`, false},
+		{"not json", `polygon`, true},
+		{"unsupported type", `{"type":"LineString","coordinates":[[55.1,25.1],[55.2,25.2]]}`, true},
+		{"bad coordinates", `{"type":"Polygon","coordinates":"55.1,25.1"}`, true},
+		{"no coordinates", `{"type":"MultiPolygon","coordinates":[]}`, true},
+		{"no rings", `{"type":"Polygon","coordinates":[]}`, true},
+		{"short ring", `{"type":"Polygon","coordinates":[[[55.1,25.1],[55.2,25.1],[55.1,25.1]]]}`, true},
+		{"open ring", `{"type":"Polygon","coordinates":[[[55.1,25.1],[55.2,25.1],[55.2,25.2],[55.1,25.2]]]}`, true},
+		{"position out of range", `{"type":"Polygon","coordinates":[[[55.1,25.1],[55.2,91],[55.2,25.2],[55.1,25.1]]]}`, true},
+		{"short position", `{"type":"Polygon","coordinates":[[[55.1,25.1],[55.2],[55.2,25.2],[55.1,25.1]]]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePolygon(json.RawMessage(tt.geometry)); (err != nil) != tt.wantErr {
+				t.Errorf("validatePolygon() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCoordinate(t *testing.T) {
+	tests := []struct {
+		position []float64
+		wantErr  bool
+	}{
+		{[]float64{55.1, 25.1}, false},
+		{[]float64{-180, -90}, false},
+		{[]float64{180, 90}, false},
+		{[]float64{55.1, 25.1, 10}, false},
+		{nil, true},
+		{[]float64{55.1}, true},
+		{[]float64{180.1, 25.1}, true},
+		{[]float64{-180.1, 25.1}, true},
+		{[]float64{55.1, 90.1}, true},
+		{[]float64{55.1, -90.1}, true},
+	}
+
+	for _, tt := range tests {
+		if err := validateCoordinate(tt.position); (err != nil) != tt.wantErr {
+			t.Errorf("validateCoordinate(%v) error = %v, want error %v", tt.position, err, tt.wantErr)
+		}
+	}
+}
@@ -4,21 +4,29 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/imkonsowa/restaurants-rag/config"
+	"github.com/imkonsowa/restaurants-rag/lang"
 	"github.com/imkonsowa/restaurants-rag/models"
+	"github.com/lib/pq"
 	"github.com/pgvector/pgvector-go"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
 type Pg struct {
-	db *gorm.DB
+	db        *gorm.DB
+	retrieval config.Retrieval
 }
 
-func NewRestaurantPg(connStr string) (*Pg, error) {
+func NewRestaurantPg(connStr string, retrieval config.Retrieval) (*Pg, error) {
 	newLogger := logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags),
 		logger.Config{
@@ -37,53 +45,127 @@ func NewRestaurantPg(connStr string) (*Pg, error) {
 		return nil, err
 	}
 
-	return &Pg{db: db}, nil
+	return &Pg{db: db, retrieval: retrieval}, nil
 }
 
 type SearchFilter struct {
-	PriceRange  string    `json:"price_range,omitempty"`
-	MinRating   float64   `json:"min_rating,omitempty"`
-	MaxDistance float64   `json:"max_distance"`
-	Location    *GeoPoint `json:"location"`
+	PriceRange  string     `json:"price_range,omitempty"` // price tier, one of $, $$ or $$$
+	MinPrice    *float64   `json:"min_price,omitempty"`
+	MaxPrice    *float64   `json:"max_price,omitempty"`
+	Badges      []string   `json:"badges,omitempty"` // lowercased badges the restaurant must have
+	MinRating   float64    `json:"min_rating,omitempty"`
+	MaxDistance float64    `json:"max_distance"`
+	Location    *GeoPoint  `json:"location"`
+	Mode        SearchMode `json:"mode,omitempty"`
+	Limit       int        `json:"limit,omitempty"`
+	ExcludeIDs  []uint64   `json:"exclude_ids,omitempty"`
+	RestrictIDs []uint64   `json:"restrict_ids,omitempty"` // only these restaurants when set, used to narrow previous results
+	OpenNow     bool       `json:"open_now,omitempty"`
+	OpenAt      string     `json:"open_at,omitempty"` // HH:MM in the restaurant's local time
+	AreaID      uint64     `json:"area_id,omitempty"` // gazetteer area the restaurants must be in
+	Place       string     `json:"place,omitempty"`   // name of the resolved gazetteer place
+	Bounds      string     `json:"bounds,omitempty"`  // GeoJSON geometry replacing the distance radius
+	Sort        SortOrder  `json:"sort,omitempty"`
+
+	SimilarityThreshold float64 `json:"similarity_threshold"`
+	ItemsPerRestaurant  int     `json:"items_per_restaurant,omitempty"` // 0 returns every matching item
+}
+
+// Validate applies the bounds of the search options to a filter that did not come from them, e.g. a cursor.
+func (f *SearchFilter) Validate() error {
+	if _, err := ParseSearchMode(string(f.Mode)); err != nil {
+		return err
+	}
+	if _, err := ParseSortOrder(string(f.Sort)); err != nil {
+		return err
+	}
+	if f.Limit < 1 || f.Limit > MaxResultLimit {
+		return fmt.Errorf("limit must be between 1 and %d", MaxResultLimit)
+	}
+	if f.SimilarityThreshold < 0 || f.SimilarityThreshold > 1 {
+		return fmt.Errorf("threshold must be between 0 and 1")
+	}
+	if f.ItemsPerRestaurant < 0 || f.ItemsPerRestaurant > MaxResultLimit {
+		return fmt.Errorf("items_per_restaurant must be between 0 and %d", MaxResultLimit)
+	}
+	if f.MinRating < 0 || f.MinRating > 5 {
+		return fmt.Errorf("rating must be between 0 and 5")
+	}
+	if f.MaxDistance < 0 {
+		return fmt.Errorf("distance must not be negative")
+	}
+	if (f.MinPrice != nil && *f.MinPrice < 0) || (f.MaxPrice != nil && *f.MaxPrice < 0) {
+		return fmt.Errorf("prices must not be negative")
+	}
+	switch f.PriceRange {
+	case "", PriceTierLow, PriceTierMid, PriceTierHigh:
+	default:
+		return fmt.Errorf("price tier must be one of $, $$ or $$$")
+	}
+	if f.OpenAt != "" && !validClock(f.OpenAt) {
+		return fmt.Errorf("open_at must be a HH:MM time")
+	}
+	if f.Location != nil && (f.Location.Lat < -90 || f.Location.Lat > 90 || f.Location.Long < -180 || f.Location.Long > 180) {
+		return fmt.Errorf("invalid location")
+	}
+	// Every page adds the restaurants it returned, more than this is not a real pagination.
+	if len(f.ExcludeIDs) > maxCursorExcludes || len(f.RestrictIDs) > maxCursorExcludes {
+		return fmt.Errorf("too many restaurant ids")
+	}
+
+	return nil
 }
 
+const maxCursorExcludes = 1000
+
+var (
+	// menuItemTSVector must stay in sync with the full-text indexes in platform/sql/init.sql. Documents are
+	// normalised like the keyword queries so that Arabic spelling variants match.
+	menuItemTSVector   = "to_tsvector('english', " + lang.NormalizeSQL("menu_items.name || ' ' || coalesce(menu_items.local_name, '') || ' ' || menu_items.description") + ")"
+	restaurantTSVector = "to_tsvector('english', " + lang.NormalizeSQL("restaurants.name || ' ' || coalesce(restaurants.local_name, '')") + ")"
+)
+
+// keywordTSQuery ORs the query terms so that a partial match like "lobster" in "find me a lobster roll" still ranks.
+const keywordTSQuery = "CROSS JOIN to_tsquery('english', replace(plainto_tsquery('english', ?)::text, ' & ', ' | ')) AS keyword_query"
+
 func (s *Pg) Search(
 	ctx context.Context,
-	queryVector []float32,
+	queryText string,
+	queryVectors [][]float32,
 	filter SearchFilter,
-) ([]models.RestaurantWithMenuItems, error) {
-	vec := pgvector.NewVector(queryVector)
+) ([]SearchResult, error) {
+	mode := filter.Mode
+	if mode == "" {
+		mode = SearchMode(s.retrieval.Mode)
+	}
 
-	query := s.db.WithContext(ctx).
-		Table("menu_items").
-		Select("menu_items.*, restaurant_id, 1 - (menu_items.embedding <=> ?) as similarity", vec).
-		Where("1 - (menu_items.embedding <=> ?) >= 0.6", vec).
-		Order("similarity DESC").
-		Joins("JOIN restaurants ON menu_items.restaurant_id = restaurants.id")
+	var rankings []itemRanking
 
-	if filter.MaxDistance > 0 && filter.Location != nil {
-		query = query.Where(
-			"ST_Distance(restaurants.location::geography, ST_SetSRID(ST_MakePoint(?, ?), 4326)) <= ?",
-			filter.Location.Lat, filter.Location.Long, filter.MaxDistance,
-		)
-	}
-	if filter.MinRating > 0 {
-		query = query.Where("restaurants.rating >= ?", filter.MinRating)
+	if mode != SearchModeKeyword && len(queryVectors) == 0 {
+		return nil, fmt.Errorf("query vector is required for %s search", mode)
 	}
 
-	var matchingItems []struct {
-		models.MenuItem
-		RestaurantID uint64
-		Similarity   float64
+	if mode != SearchModeKeyword && s.retrieval.VectorWeight > 0 {
+		// Expanded queries share the vector weight so that they don't outweigh the keyword ranking.
+		weight := s.retrieval.VectorWeight / float64(len(queryVectors))
+		for _, queryVector := range queryVectors {
+			items, err := s.vectorSearch(ctx, queryVector, filter)
+			if err != nil {
+				return nil, err
+			}
+			rankings = append(rankings, itemRanking{Signal: SignalVector, Weight: weight, Items: items})
+		}
 	}
-	if err := query.Scan(&matchingItems).Error; err != nil {
-		return nil, fmt.Errorf("query menu items: %w", err)
+	if (mode == SearchModeKeyword || mode == SearchModeHybrid) && s.retrieval.KeywordWeight > 0 {
+		items, err := s.keywordSearch(ctx, queryText, filter)
+		if err != nil {
+			return nil, err
+		}
+		rankings = append(rankings, itemRanking{Signal: SignalKeyword, Weight: s.retrieval.KeywordWeight, Items: items})
 	}
 
-	type restaurantMatch struct {
-		items          []models.MenuItem
-		bestSimilarity float64
-	}
+	matchingItems := fuseRankings(rankings, s.retrieval.Fusion, s.retrieval.RRFK)
+
 	matches := make(map[uint64]*restaurantMatch)
 	var orderedIDs []uint64
 
@@ -94,17 +176,64 @@ func (s *Pg) Search(
 			matches[item.RestaurantID] = m
 			orderedIDs = append(orderedIDs, item.RestaurantID)
 		}
-		m.items = append(m.items, item.MenuItem)
+		if filter.ItemsPerRestaurant == 0 || len(m.items) < filter.ItemsPerRestaurant {
+			m.items = append(m.items, item.MenuItem)
+			m.matchedItems = append(m.matchedItems, newMatchedItem(item))
+		}
 		if item.Similarity > m.bestSimilarity {
 			m.bestSimilarity = item.Similarity
 		}
+		if item.Score > m.itemScore {
+			m.itemScore = item.Score
+			m.itemSignals = item.Signals
+		}
+		if m.minItemPrice == 0 || item.Price < m.minItemPrice {
+			m.minItemPrice = item.Price
+		}
+	}
+
+	if mode != SearchModeKeyword {
+		if s.retrieval.RestaurantWeight > 0 {
+			restaurantHits, err := s.restaurantSearch(ctx, queryVectors[0], filter, orderedIDs)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, hit := range restaurantHits {
+				m, exists := matches[hit.ID]
+				if !exists {
+					m = &restaurantMatch{}
+					matches[hit.ID] = m
+					orderedIDs = append(orderedIDs, hit.ID)
+				}
+				m.restaurantSimilarity = hit.Similarity
+			}
+		}
+
+		s.blendRestaurantScores(matches)
+	} else {
+		for _, m := range matches {
+			m.score = m.itemScore
+			m.signals = m.itemSignals
+		}
 	}
 
 	if len(orderedIDs) == 0 {
 		return nil, nil
 	}
-	if len(orderedIDs) > 10 {
-		orderedIDs = orderedIDs[:10]
+
+	facts, err := s.restaurantFacts(ctx, orderedIDs, filter.Location)
+	if err != nil {
+		return nil, err
+	}
+	sortRestaurantIDs(orderedIDs, matches, facts, filter.Sort)
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultResultLimit
+	}
+	if len(orderedIDs) > limit {
+		orderedIDs = orderedIDs[:limit]
 	}
 
 	var restaurants []models.Restaurant
@@ -114,20 +243,343 @@ func (s *Pg) Search(
 
 	restaurantMap := make(map[uint64]models.Restaurant)
 	for _, r := range restaurants {
+		r.PriceTier = facts[r.ID].PriceTier
+		r.OpenNow = facts[r.ID].OpenNow
 		restaurantMap[r.ID] = r
 	}
 
-	results := make([]models.RestaurantWithMenuItems, 0, len(orderedIDs))
+	results := make([]SearchResult, 0, len(orderedIDs))
 	for _, id := range orderedIDs {
-		results = append(results, models.RestaurantWithMenuItems{
-			Restaurant: restaurantMap[id],
-			MenuItems:  matches[id].items,
+		m := matches[id]
+		results = append(results, SearchResult{
+			RestaurantWithMenuItems: models.RestaurantWithMenuItems{
+				Restaurant: restaurantMap[id],
+				MenuItems:  m.items,
+			},
+			Distance:    facts[id].Distance,
+			Similarity:  max(m.bestSimilarity, m.restaurantSimilarity),
+			Score:       m.score,
+			Explanation: explainMatch(m, facts[id], restaurantMap[id], filter),
 		})
 	}
 
 	return results, nil
 }
 
+type restaurantMatch struct {
+	items                []models.MenuItem
+	matchedItems         []MatchedItem
+	bestSimilarity       float64
+	itemScore            float64
+	itemSignals          map[string]float64 // signal contributions of the best scoring item
+	restaurantSimilarity float64
+	score                float64
+	signals              map[string]float64 // signal contributions to score
+	minItemPrice         float64
+}
+
+type restaurantHit struct {
+	ID         uint64
+	Similarity float64
+}
+
+// restaurantSearch scores restaurants by their own embedding. It returns the restaurants above the similarity
+// threshold together with the candidates already found through their menu items.
+func (s *Pg) restaurantSearch(
+	ctx context.Context,
+	queryVector []float32,
+	filter SearchFilter,
+	candidateIDs []uint64,
+) ([]restaurantHit, error) {
+	vec := pgvector.NewVector(queryVector)
+
+	query := s.db.WithContext(ctx).
+		Table("restaurants").
+		Select("restaurants.id, 1 - (restaurants.embedding <=> ?) as similarity", vec).
+		Where("restaurants.embedding IS NOT NULL").
+		Where(s.db.Where("1 - (restaurants.embedding <=> ?) >= ?", vec, filter.SimilarityThreshold).Or("restaurants.id IN ?", candidateIDs)).
+		Order("similarity DESC")
+	query = applyRestaurantFilter(query, filter)
+
+	var hits []restaurantHit
+	if err := query.Scan(&hits).Error; err != nil {
+		return nil, fmt.Errorf("query restaurants: %w", err)
+	}
+
+	return hits, nil
+}
+
+// blendRestaurantScores combines the restaurant's own similarity with its best menu item score.
+// Item scores are normalised by the best item score so both tiers are on a 0-1 scale.
+func (s *Pg) blendRestaurantScores(matches map[uint64]*restaurantMatch) {
+	var maxItemScore float64
+	for _, m := range matches {
+		if m.itemScore > maxItemScore {
+			maxItemScore = m.itemScore
+		}
+	}
+
+	restaurantWeight := s.retrieval.RestaurantWeight
+	itemWeight := s.retrieval.ItemWeight
+
+	totalWeight := restaurantWeight + itemWeight
+
+	for _, m := range matches {
+		var itemScore float64
+		if maxItemScore > 0 {
+			itemScore = m.itemScore / maxItemScore
+		}
+		m.score = (restaurantWeight*m.restaurantSimilarity + itemWeight*itemScore) / totalWeight
+
+		m.signals = map[string]float64{SignalRestaurant: restaurantWeight * m.restaurantSimilarity / totalWeight}
+		for signal, contribution := range m.itemSignals {
+			if maxItemScore > 0 {
+				m.signals[signal] = itemWeight * contribution / maxItemScore / totalWeight
+			}
+		}
+	}
+}
+
+// menuItemColumns are the menu_items columns of search results, the embeddings are not sent back.
+const menuItemColumns = "menu_items.id, menu_items.restaurant_id, menu_items.category, menu_items.name, " +
+	"menu_items.local_name, menu_items.price, menu_items.description, menu_items.created_at, menu_items.updated_at"
+
+// vectorSearch returns the menu items nearest to the query vector above the similarity threshold. The nearest
+// candidates are ordered by distance with a limit so that the ivfflat index is used, the threshold is applied
+// to them afterwards.
+func (s *Pg) vectorSearch(ctx context.Context, queryVector []float32, filter SearchFilter) ([]rankedItem, error) {
+	vec := pgvector.NewVector(queryVector)
+
+	candidates := s.retrieval.VectorCandidates
+	if candidates < 1 {
+		candidates = DefaultVectorCandidates
+	}
+
+	nearest := s.db.
+		Table("menu_items").
+		Select(menuItemColumns+", menu_items.embedding <=> ? AS distance", vec).
+		Joins("JOIN restaurants ON menu_items.restaurant_id = restaurants.id").
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "menu_items.embedding <=> ?", Vars: []interface{}{vec}}}).
+		Limit(candidates)
+	nearest = applySearchFilter(nearest, filter)
+
+	query := s.db.WithContext(ctx).
+		Table("(?) AS nearest", nearest).
+		Select("nearest.*, 1 - nearest.distance AS similarity").
+		Where("1 - nearest.distance >= ?", filter.SimilarityThreshold).
+		Order("nearest.distance")
+
+	var items []rankedItem
+	if err := query.Scan(&items).Error; err != nil {
+		return nil, fmt.Errorf("query menu items: %w", err)
+	}
+
+	return items, nil
+}
+
+func (s *Pg) keywordSearch(ctx context.Context, queryText string, filter SearchFilter) ([]rankedItem, error) {
+	queryText = lang.Normalize(queryText)
+	if strings.TrimSpace(queryText) == "" {
+		return nil, nil
+	}
+
+	candidates := s.retrieval.KeywordCandidates
+	if candidates < 1 {
+		candidates = DefaultKeywordCandidates
+	}
+
+	query := s.db.WithContext(ctx).
+		Table("menu_items").
+		Select(fmt.Sprintf(
+			"%s, ts_rank(setweight(%s, 'B') || setweight(%s, 'A'), keyword_query) as keyword_rank",
+			menuItemColumns, menuItemTSVector, restaurantTSVector,
+		)).
+		Joins("JOIN restaurants ON menu_items.restaurant_id = restaurants.id").
+		Joins(keywordTSQuery, queryText).
+		Where(fmt.Sprintf("(%s @@ keyword_query OR %s @@ keyword_query)", menuItemTSVector, restaurantTSVector)).
+		Order("keyword_rank DESC").
+		Limit(candidates)
+	query = applySearchFilter(query, filter)
+
+	var items []rankedItem
+	if err := query.Scan(&items).Error; err != nil {
+		return nil, fmt.Errorf("keyword query menu items: %w", err)
+	}
+
+	return items, nil
+}
+
+// applySearchFilter applies every constraint of the filter to a menu_items query joined with restaurants. The
+// price bounds apply to the items themselves, which also satisfies the restaurant level price check.
+func applySearchFilter(query *gorm.DB, filter SearchFilter) *gorm.DB {
+	query = applyRestaurantConstraints(query, filter)
+
+	if filter.MinPrice != nil {
+		query = query.Where("menu_items.price >= ?", *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		query = query.Where("menu_items.price <= ?", *filter.MaxPrice)
+	}
+
+	return query
+}
+
+// applyRestaurantFilter applies the constraints of the filter to a restaurants query. Price bounds are
+// satisfied when at least one menu item of the restaurant falls in the range.
+func applyRestaurantFilter(query *gorm.DB, filter SearchFilter) *gorm.DB {
+	query = applyRestaurantConstraints(query, filter)
+
+	if filter.MinPrice != nil || filter.MaxPrice != nil {
+		minPrice, maxPrice := 0.0, math.MaxFloat64
+		if filter.MinPrice != nil {
+			minPrice = *filter.MinPrice
+		}
+		if filter.MaxPrice != nil {
+			maxPrice = *filter.MaxPrice
+		}
+		query = query.Where(
+			"EXISTS (SELECT 1 FROM menu_items price_items WHERE price_items.restaurant_id = restaurants.id AND price_items.price BETWEEN ? AND ?)",
+			minPrice, maxPrice,
+		)
+	}
+
+	return query
+}
+
+// applyRestaurantConstraints applies the restaurant level constraints other than the price bounds.
+func applyRestaurantConstraints(query *gorm.DB, filter SearchFilter) *gorm.DB {
+	if filter.Bounds != "" {
+		// GeoJSON is longitude first while restaurant locations are stored latitude first.
+		query = query.Where(
+			"ST_Intersects(restaurants.location, ST_FlipCoordinates(ST_SetSRID(ST_GeomFromGeoJSON(?), 4326))::geography)",
+			filter.Bounds,
+		)
+	} else if filter.MaxDistance > 0 && filter.Location != nil {
+		query = query.Where(
+			"ST_Distance(restaurants.location::geography, ST_SetSRID(ST_MakePoint(?, ?), 4326)) <= ?",
+			filter.Location.Lat, filter.Location.Long, filter.MaxDistance,
+		)
+	}
+	if filter.MinRating > 0 {
+		query = query.Where("restaurants.rating >= ?", filter.MinRating)
+	}
+	if filter.AreaID != 0 {
+		query = query.Where(
+			"EXISTS (SELECT 1 FROM places WHERE places.id = ? AND ST_Covers(places.geom, restaurants.location::geometry))",
+			filter.AreaID,
+		)
+	}
+	if filter.OpenNow {
+		query = query.Where("restaurant_open_at(restaurants.id, restaurants.timezone, ?)", time.Now())
+	}
+	if filter.OpenAt != "" {
+		// The time of day is taken on the current local date of each restaurant.
+		query = query.Where(
+			"restaurant_open_at(restaurants.id, restaurants.timezone, "+
+				"((?::timestamptz AT TIME ZONE restaurants.timezone)::date + ?::time) AT TIME ZONE restaurants.timezone)",
+			time.Now(), filter.OpenAt,
+		)
+	}
+	if len(filter.ExcludeIDs) > 0 {
+		query = query.Where("restaurants.id NOT IN ?", filter.ExcludeIDs)
+	}
+	if len(filter.RestrictIDs) > 0 {
+		query = query.Where("restaurants.id IN ?", filter.RestrictIDs)
+	}
+	if len(filter.Badges) > 0 {
+		query = query.Where(
+			"ARRAY(SELECT lower(badge) FROM unnest(restaurants.badges) AS badge) @> ?::text[]",
+			pq.StringArray(filter.Badges),
+		)
+	}
+	if filter.PriceRange != "" {
+		query = query.Where(
+			"restaurants.id IN (SELECT restaurant_id FROM restaurant_price_tiers WHERE price_tier = ?)",
+			filter.PriceRange,
+		)
+	}
+	return query
+}
+
+type restaurantFact struct {
+	ID          uint64
+	Rating      float64
+	Distance    *float64
+	MedianPrice *float64
+	PriceTier   string
+	OpenNow     *bool
+}
+
+// restaurantOpenNow is NULL for restaurants without opening hours, whose open state is unknown.
+const restaurantOpenNow = `CASE
+	WHEN EXISTS (SELECT 1 FROM opening_hours WHERE opening_hours.restaurant_id = restaurants.id)
+		OR EXISTS (SELECT 1 FROM opening_hour_exceptions WHERE opening_hour_exceptions.restaurant_id = restaurants.id)
+	THEN restaurant_open_at(restaurants.id, restaurants.timezone, ?)
+END`
+
+// restaurantFacts loads the per-restaurant values used for sorting and display: rating, distance from
+// the user location, the menu price tier and whether the restaurant is open now.
+func (s *Pg) restaurantFacts(ctx context.Context, restaurantIDs []uint64, location *GeoPoint) (map[uint64]restaurantFact, error) {
+	distance := "NULL::float8"
+	var args []interface{}
+	if location != nil {
+		distance = "ST_Distance(restaurants.location::geography, ST_SetSRID(ST_MakePoint(?, ?), 4326))"
+		args = append(args, location.Lat, location.Long)
+	}
+
+	var facts []restaurantFact
+	if err := s.db.WithContext(ctx).
+		Table("restaurants").
+		Select(
+			"restaurants.id, restaurants.rating, "+distance+" AS distance, tiers.median_price, tiers.price_tier, "+restaurantOpenNow+" AS open_now",
+			append(args, time.Now())...,
+		).
+		Joins("LEFT JOIN restaurant_price_tiers tiers ON tiers.restaurant_id = restaurants.id").
+		Where("restaurants.id IN ?", restaurantIDs).
+		Scan(&facts).Error; err != nil {
+		return nil, fmt.Errorf("fetch restaurant facts: %w", err)
+	}
+
+	result := make(map[uint64]restaurantFact, len(facts))
+	for _, f := range facts {
+		result[f.ID] = f
+	}
+
+	return result, nil
+}
+
+// sortRestaurantIDs orders the candidates by the requested sort, falling back to relevance for ties.
+func sortRestaurantIDs(ids []uint64, matches map[uint64]*restaurantMatch, facts map[uint64]restaurantFact, order SortOrder) {
+	sort.SliceStable(ids, func(i, j int) bool {
+		return matches[ids[i]].score > matches[ids[j]].score
+	})
+
+	switch order {
+	case SortByDistance:
+		sort.SliceStable(ids, func(i, j int) bool {
+			a, b := facts[ids[i]].Distance, facts[ids[j]].Distance
+			return a != nil && (b == nil || *a < *b)
+		})
+	case SortByRating:
+		sort.SliceStable(ids, func(i, j int) bool {
+			return facts[ids[i]].Rating > facts[ids[j]].Rating
+		})
+	case SortByPrice:
+		price := func(id uint64) float64 {
+			if p := matches[id].minItemPrice; p > 0 {
+				return p
+			}
+			if p := facts[id].MedianPrice; p != nil {
+				return *p
+			}
+			return math.MaxFloat64
+		}
+		sort.SliceStable(ids, func(i, j int) bool {
+			return price(ids[i]) < price(ids[j])
+		})
+	}
+}
+
 func (s *Pg) Create(
 	ctx context.Context,
 	items []models.RestaurantWithMenuItems,
@@ -149,6 +601,24 @@ func (s *Pg) Create(
 			if err := tx.Debug().Create(&menuItems).Error; err != nil {
 				return fmt.Errorf("failed to create menu items: %w", err)
 			}
+
+			for i := range item.OpeningHours {
+				item.OpeningHours[i].RestaurantID = item.Restaurant.ID
+			}
+			if len(item.OpeningHours) > 0 {
+				if err := tx.Create(&item.OpeningHours).Error; err != nil {
+					return fmt.Errorf("failed to create opening hours: %w", err)
+				}
+			}
+
+			for i := range item.Exceptions {
+				item.Exceptions[i].RestaurantID = item.Restaurant.ID
+			}
+			if len(item.Exceptions) > 0 {
+				if err := tx.Create(&item.Exceptions).Error; err != nil {
+					return fmt.Errorf("failed to create opening hours exceptions: %w", err)
+				}
+			}
 		}
 
 		return nil
@@ -163,3 +633,97 @@ func (s *Pg) ListRestaurants(ctx context.Context) ([]models.Restaurant, error) {
 
 	return restaurants, nil
 }
+
+// GetRestaurant returns the restaurant with its price tier, or nil when it does not exist.
+func (s *Pg) GetRestaurant(ctx context.Context, id uint64) (*models.Restaurant, error) {
+	var restaurants []models.Restaurant
+	if err := s.db.WithContext(ctx).Where("id = ?", id).Limit(1).Find(&restaurants).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch restaurant: %w", err)
+	}
+	if len(restaurants) == 0 {
+		return nil, nil
+	}
+
+	facts, err := s.restaurantFacts(ctx, []uint64{id}, nil)
+	if err != nil {
+		return nil, err
+	}
+	restaurants[0].PriceTier = facts[id].PriceTier
+	restaurants[0].OpenNow = facts[id].OpenNow
+
+	return &restaurants[0], nil
+}
+
+// GetMenu returns the menu items of a restaurant grouped by category.
+func (s *Pg) GetMenu(ctx context.Context, restaurantID uint64) ([]models.MenuItem, error) {
+	var items []models.MenuItem
+	if err := s.db.WithContext(ctx).
+		Where("restaurant_id = ?", restaurantID).
+		Order("category, price").
+		Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch menu: %w", err)
+	}
+
+	return items, nil
+}
+
+type AreaCount struct {
+	Area        string `json:"area"`
+	Restaurants int    `json:"restaurants"`
+}
+
+func (s *Pg) ListAreas(ctx context.Context) ([]AreaCount, error) {
+	var areas []AreaCount
+	if err := s.db.WithContext(ctx).
+		Model(&models.Restaurant{}).
+		Select("area, COUNT(*) AS restaurants").
+		Where("area <> ''").
+		Group("area").
+		Order("area").
+		Scan(&areas).Error; err != nil {
+		return nil, fmt.Errorf("failed to list areas: %w", err)
+	}
+
+	return areas, nil
+}
+
+// ListInBounds returns the restaurants matching the filter without a query, the best rated first. It is used
+// to browse a map area.
+func (s *Pg) ListInBounds(ctx context.Context, filter SearchFilter) ([]SearchResult, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultResultLimit
+	}
+
+	query := s.db.WithContext(ctx).Model(&models.Restaurant{}).Order("restaurants.rating DESC, restaurants.id").Limit(limit)
+
+	var restaurants []models.Restaurant
+	if err := applyRestaurantFilter(query, filter).Find(&restaurants).Error; err != nil {
+		return nil, fmt.Errorf("failed to list restaurants: %w", err)
+	}
+	if len(restaurants) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	ids := make([]uint64, 0, len(restaurants))
+	for _, r := range restaurants {
+		ids = append(ids, r.ID)
+	}
+	facts, err := s.restaurantFacts(ctx, ids, filter.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(restaurants))
+	for _, r := range restaurants {
+		r.PriceTier = facts[r.ID].PriceTier
+		r.OpenNow = facts[r.ID].OpenNow
+		results = append(results, SearchResult{
+			RestaurantWithMenuItems: models.RestaurantWithMenuItems{Restaurant: r},
+			Distance:                facts[r.ID].Distance,
+			Explanation:             explainMatch(&restaurantMatch{}, facts[r.ID], r, filter),
+		})
+	}
+
+	return results, nil
+}
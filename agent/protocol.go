@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolV1 is the WebSocket subprotocol of the versioned search protocol. Clients opt in by requesting it,
+// e.g. new WebSocket(url, "restaurants.v1"); connections without it keep the unversioned protocol.
+//
+// Client messages:
+//
+//	{"type": "query", "id": "q1", "input": "...", "location": {"lat": ..., "long": ...}, "options": {...}}
+//	{"type": "cancel", "id": "q1"}
+//	{"type": "ping", "id": "p1"}
+//	{"type": "reset"}
+//
+// Server messages carry the id of the request they belong to:
+//
+//	{"v": 1, "type": "parsed", "id": "q1", "data": <parsed input or refinement>}
+//	{"v": 1, "type": "results", "id": "q1", "data": {"results": [...], "cursor": "..."}}
+//	{"v": 1, "type": "token", "id": "q1", "data": {"text": "..."}}
+//	{"v": 1, "type": "done", "id": "q1", "data": {"cancelled": false}}
+//	{"v": 1, "type": "error", "id": "q1", "data": {"code": "search_failed", "message": "..."}}
+//
+// plus thinking, grounding and debug events, and a pong answering each ping. A query replaces the one in flight,
+// which is cancelled first; every query ends with exactly one done message.
+const ProtocolV1 = "restaurants.v1"
+
+const ProtocolVersion = 1
+
+const (
+	ClientCancel = "cancel"
+	ClientPing   = "ping"
+)
+
+const (
+	ServerParsed    = "parsed"
+	ServerResults   = "results"
+	ServerToken     = "token"
+	ServerThinking  = "thinking"
+	ServerGrounding = "grounding"
+	ServerDebug     = "debug"
+	ServerDone      = "done"
+	ServerError     = "error"
+	ServerPong      = "pong"
+)
+
+type ServerMessage struct {
+	Version int         `json:"v"`
+	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type TextPayload struct {
+	Text string `json:"text"`
+}
+
+type DonePayload struct {
+	Cancelled bool `json:"cancelled"`
+}
+
+type ErrorPayload struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// protocolMessage maps a pipeline event to its v1 message.
+func protocolMessage(id string, event Event) ServerMessage {
+	msg := ServerMessage{Version: ProtocolVersion, ID: id, Data: event.Data}
+
+	switch event.Type {
+	case EventParsed:
+		msg.Type = ServerParsed
+	case EventRestaurants:
+		msg.Type = ServerResults
+	case EventChat:
+		msg.Type = ServerToken
+		msg.Data = TextPayload{Text: fmt.Sprint(event.Data)}
+	case EventThinking:
+		msg.Type = ServerThinking
+		msg.Data = TextPayload{Text: fmt.Sprint(event.Data)}
+	case EventGrounding:
+		msg.Type = ServerGrounding
+	default:
+		msg.Type = ServerDebug
+	}
+
+	return msg
+}
+
+// protocolConn serialises the writes of a connection, gorilla/websocket allows a single writer at a time.
+type protocolConn struct {
+	mu sync.Mutex
+	c  *websocket.Conn
+}
+
+func (p *protocolConn) write(msg ServerMessage) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.c.WriteJSON(msg); err != nil {
+		slog.Error("failed to write to ws connection", "error", err)
+		return false
+	}
+
+	return true
+}
+
+func (p *protocolConn) writeError(id string, err error) bool {
+	return p.write(ServerMessage{Version: ProtocolVersion, Type: ServerError, ID: id, Data: ErrorPayload{Code: errorCode(err), Message: err.Error()}})
+}
+
+type inflightQuery struct {
+	id     string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// stop cancels the query and waits for its done message.
+func (q *inflightQuery) stop() {
+	if q == nil {
+		return
+	}
+	q.cancel()
+	<-q.done
+}
+
+// serveV1 runs the v1 protocol until the client disconnects. Queries run in the background so that cancel
+// and ping messages are handled while a query is in flight.
+func (a *Agent) serveV1(ctx *gin.Context, c *websocket.Conn) {
+	conn := &protocolConn{c: c}
+	state := &ConversationState{}
+	sessionID := sessionFromContext(ctx)
+
+	var current *inflightQuery
+	defer func() {
+		current.stop()
+	}()
+
+	for {
+		var msg ClientMessage
+		if err := c.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Error("failed to read from ws connection", "error", err)
+			}
+			return
+		}
+
+		switch msg.Type {
+		case ClientPing:
+			if !conn.write(ServerMessage{Version: ProtocolVersion, Type: ServerPong, ID: msg.ID}) {
+				return
+			}
+		case ClientCancel:
+			if current != nil && (msg.ID == "" || msg.ID == current.id) {
+				current.stop()
+				current = nil
+			} else if !conn.writeError(msg.ID, withCode(CodeInvalidRequest, fmt.Errorf("no query %q in flight", msg.ID))) {
+				return
+			}
+		case ClientReset:
+			current.stop()
+			current = nil
+			state.Reset()
+			if !conn.write(ServerMessage{Version: ProtocolVersion, Type: ServerDone, ID: msg.ID, Data: DonePayload{}}) {
+				return
+			}
+		case ClientQuery:
+			current.stop()
+			current = nil
+
+			id := msg.ID
+			if id == "" {
+				id = randomID()
+			}
+
+			req := SearchRequest{
+				Input:     msg.Input,
+				Location:  msg.Location,
+				Summary:   true,
+				Options:   msg.Options,
+				SessionID: sessionID,
+				State:     state,
+			}
+			if err := req.Validate(); err != nil {
+				if !conn.writeError(id, err) || !conn.write(ServerMessage{Version: ProtocolVersion, Type: ServerDone, ID: id, Data: DonePayload{}}) {
+					return
+				}
+				continue
+			}
+
+			queryCtx, cancel := context.WithCancel(ctx.Request.Context())
+			current = &inflightQuery{id: id, cancel: cancel, done: make(chan struct{})}
+			go a.runQuery(queryCtx, conn, current, req)
+		default:
+			if !conn.writeError(msg.ID, withCode(CodeInvalidRequest, fmt.Errorf("unknown message type %q", msg.Type))) {
+				return
+			}
+		}
+	}
+}
+
+// runQuery streams the events of a query and ends it with a done message. Cancelling ctx aborts the model
+// calls of the pipeline.
+func (a *Agent) runQuery(ctx context.Context, conn *protocolConn, query *inflightQuery, req SearchRequest) {
+	defer close(query.done)
+	defer query.cancel()
+
+	for result := range a.handler.SearchByUserQuery(ctx, req) {
+		if result.Err != nil {
+			if result.Err != io.EOF && ctx.Err() == nil && !conn.writeError(query.id, result.Err) {
+				return
+			}
+			break
+		}
+
+		if !conn.write(protocolMessage(query.id, result.Msg)) {
+			return
+		}
+	}
+
+	conn.write(ServerMessage{
+		Version: ProtocolVersion,
+		Type:    ServerDone,
+		ID:      query.id,
+		Data:    DonePayload{Cancelled: ctx.Err() != nil},
+	})
+}
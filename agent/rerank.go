@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	DefaultRerankCandidates = 20
+	DefaultRerankTimeout    = 30 * time.Second
+
+	rerankConcurrency = 4
+)
+
+type RerankScore struct {
+	RestaurantID uint64  `json:"restaurant_id"`
+	Name         string  `json:"name"`
+	Score        float64 `json:"score"`
+}
+
+func (h *Handler) rerankCandidates() int {
+	if h.cfg.Rerank.Candidates < 1 {
+		return DefaultRerankCandidates
+	}
+
+	return h.cfg.Rerank.Candidates
+}
+
+// Rerank scores every (restaurant, matched items) pair against the user query with the parser model
+// and returns the results sorted by that score. Ties keep the original search order.
+func (h *Handler) Rerank(
+	ctx context.Context,
+	userInput string,
+	results []SearchResult,
+) ([]SearchResult, []RerankScore, error) {
+	timeout := h.cfg.Rerank.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRerankTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	scores := make([]RerankScore, len(results))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(rerankConcurrency)
+
+	for i, result := range results {
+		g.Go(func() error {
+			score, err := h.scoreCandidate(gctx, userInput, result)
+			if err != nil {
+				return fmt.Errorf("score restaurant %d: %w", result.Restaurant.ID, err)
+			}
+
+			scores[i] = RerankScore{
+				RestaurantID: result.Restaurant.ID,
+				Name:         result.Restaurant.Name,
+				Score:        score,
+			}
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return results, nil, err
+	}
+
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]].Score > scores[order[j]].Score
+	})
+
+	reranked := make([]SearchResult, len(results))
+	sortedScores := make([]RerankScore, len(results))
+	for i, idx := range order {
+		reranked[i] = results[idx]
+		sortedScores[i] = scores[idx]
+		if explanation := reranked[i].Explanation; explanation != nil {
+			explanation.Rerank = &sortedScores[i].Score
+		}
+	}
+
+	return reranked, sortedScores, nil
+}
+
+func (h *Handler) scoreCandidate(ctx context.Context, userInput string, result SearchResult) (float64, error) {
+	var candidate strings.Builder
+	candidate.WriteString(result.Restaurant.Stringify())
+	candidate.WriteString("\n")
+	for _, item := range result.MenuItems {
+		candidate.WriteString("\t" + item.Stringify() + "\n")
+	}
+
+	prompt := fmt.Sprintf("User query: %q\n\nCandidate:\n%s\nReturn only valid JSON.", userInput, candidate.String())
+
+	var judged struct {
+		Score float64 `json:"score"`
+	}
+	if err := h.generateJSON(ctx, h.parserLLM, RerankSysPrompt, prompt, &judged); err != nil {
+		return 0, err
+	}
+
+	return min(max(judged.Score, 0), 10), nil
+}
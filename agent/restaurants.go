@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkonsowa/restaurants-rag/models"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RestaurantUpdate is the body of PUT and PATCH /restaurants/:id. PUT replaces every field, omitted optional
+// fields are reset, while PATCH only changes the fields present. Opening hours are not edited here.
+type RestaurantUpdate struct {
+	Name      *string    `json:"name"`
+	LocalName *string    `json:"local_name"`
+	Area      *string    `json:"area"`
+	Rating    *float64   `json:"rating"`
+	Badges    *[]string  `json:"badges"`
+	Location  *GeoPoint  `json:"location"`
+	Timezone  *string    `json:"timezone"`
+	UpdatedAt *time.Time `json:"updated_at"` // updated_at of the version being edited
+}
+
+func (u *RestaurantUpdate) Validate(partial bool) error {
+	fields := FieldErrors{}
+
+	if u.UpdatedAt == nil {
+		fields.Add("updated_at", "is required, send the updated_at of the restaurant being edited")
+	}
+	if !partial {
+		if u.Name == nil {
+			fields.Add("name", "is required")
+		}
+		if u.Area == nil {
+			fields.Add("area", "is required")
+		}
+		if u.Rating == nil {
+			fields.Add("rating", "is required")
+		}
+		if u.Location == nil {
+			fields.Add("location", "is required")
+		}
+	}
+
+	if u.Name != nil && strings.TrimSpace(*u.Name) == "" {
+		fields.Add("name", "must not be empty")
+	}
+	if u.Area != nil && strings.TrimSpace(*u.Area) == "" {
+		fields.Add("area", "must not be empty")
+	}
+	if u.Rating != nil && (*u.Rating < 1 || *u.Rating > 5) {
+		fields.Add("rating", "must be between 1 and 5")
+	}
+	if u.Location != nil {
+		if u.Location.Lat < -90 || u.Location.Lat > 90 {
+			fields.Add("location.lat", "must be between -90 and 90")
+		}
+		if u.Location.Long < -180 || u.Location.Long > 180 {
+			fields.Add("location.long", "must be between -180 and 180")
+		}
+	}
+	if u.Timezone != nil {
+		if err := validateTimezone(*u.Timezone); err != nil {
+			fields.Add("timezone", "must be an IANA timezone name")
+		}
+	}
+
+	return fields.Err()
+}
+
+// Updates returns the columns to write. The fields PUT leaves out are reset to their defaults.
+func (u *RestaurantUpdate) Updates(partial bool) map[string]interface{} {
+	updates := map[string]interface{}{}
+
+	if u.Name != nil {
+		updates["name"] = *u.Name
+	}
+	if u.LocalName != nil {
+		updates["local_name"] = *u.LocalName
+	} else if !partial {
+		updates["local_name"] = ""
+	}
+	if u.Area != nil {
+		updates["area"] = *u.Area
+	}
+	if u.Rating != nil {
+		updates["rating"] = *u.Rating
+	}
+	if u.Badges != nil {
+		updates["badges"] = pq.StringArray(*u.Badges)
+	} else if !partial {
+		updates["badges"] = pq.StringArray{}
+	}
+	if u.Location != nil {
+		updates["location"] = models.NewGeoPoint(u.Location.Lat, u.Location.Long)
+	}
+	if u.Timezone != nil && *u.Timezone != "" {
+		updates["timezone"] = *u.Timezone
+	} else if u.Timezone != nil || !partial {
+		updates["timezone"] = DefaultTimezone
+	}
+
+	return updates
+}
+
+// MenuItemUpdate is the body of the menu item endpoints. POST and PUT need every required field, PATCH only
+// changes the fields present.
+type MenuItemUpdate struct {
+	Name        *string    `json:"name"`
+	LocalName   *string    `json:"local_name"`
+	Category    *string    `json:"category"`
+	Description *string    `json:"description"`
+	Price       *float64   `json:"price"`
+	UpdatedAt   *time.Time `json:"updated_at"` // updated_at of the version being edited, not used on POST
+}
+
+func (u *MenuItemUpdate) Validate(partial, create bool) error {
+	fields := FieldErrors{}
+
+	if u.UpdatedAt == nil && !create {
+		fields.Add("updated_at", "is required, send the updated_at of the menu item being edited")
+	}
+	if !partial {
+		if u.Name == nil {
+			fields.Add("name", "is required")
+		}
+		if u.Description == nil {
+			fields.Add("description", "is required")
+		}
+		if u.Price == nil {
+			fields.Add("price", "is required")
+		}
+	}
+
+	if u.Name != nil && strings.TrimSpace(*u.Name) == "" {
+		fields.Add("name", "must not be empty")
+	}
+	if u.Description != nil && strings.TrimSpace(*u.Description) == "" {
+		fields.Add("description", "must not be empty")
+	}
+	if u.Price != nil && *u.Price <= 0 {
+		fields.Add("price", "must be positive")
+	}
+
+	return fields.Err()
+}
+
+func (u *MenuItemUpdate) Updates(partial bool) map[string]interface{} {
+	updates := map[string]interface{}{}
+
+	if u.Name != nil {
+		updates["name"] = *u.Name
+	}
+	if u.LocalName != nil {
+		updates["local_name"] = *u.LocalName
+	} else if !partial {
+		updates["local_name"] = ""
+	}
+	if u.Category != nil {
+		updates["category"] = *u.Category
+	} else if !partial {
+		updates["category"] = ""
+	}
+	if u.Description != nil {
+		updates["description"] = *u.Description
+	}
+	if u.Price != nil {
+		updates["price"] = *u.Price
+	}
+
+	return updates
+}
+
+func (u *MenuItemUpdate) ToModel(restaurantID uint64) models.MenuItem {
+	item := models.MenuItem{
+		RestaurantID: restaurantID,
+		Name:         *u.Name,
+		Description:  *u.Description,
+		Price:        *u.Price,
+	}
+	if u.LocalName != nil {
+		item.LocalName = *u.LocalName
+	}
+	if u.Category != nil {
+		item.Category = *u.Category
+	}
+
+	return item
+}
+
+// idParam parses a numeric path parameter.
+func idParam(ctx *gin.Context, name string) (uint64, error) {
+	id, err := strconv.ParseUint(ctx.Param(name), 10, 64)
+	if err != nil || id == 0 {
+		return 0, withCode(CodeInvalidRequest, fmt.Errorf("invalid %s %q", name, ctx.Param(name)))
+	}
+
+	return id, nil
+}
+
+// versionQuery parses the updated_at query parameter of deletes, required like the updated_at of edits.
+func versionQuery(ctx *gin.Context, resource string) (time.Time, error) {
+	value := ctx.Query("updated_at")
+	if value == "" {
+		return time.Time{}, FieldErrors{"updated_at": "is required, send the updated_at of the " + resource + " being deleted"}.Err()
+	}
+
+	version, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, FieldErrors{"updated_at": "must be an RFC 3339 timestamp"}.Err()
+	}
+
+	return version, nil
+}
+
+// crudError turns the storage errors of the CRUD endpoints into coded errors.
+func crudError(err error, resource string) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return withCode(CodeNotFound, fmt.Errorf("%s not found", resource))
+	case errors.Is(err, ErrConflict):
+		return withCode(CodeConflict, fmt.Errorf("%s was modified since the given updated_at, fetch it again", resource))
+	default:
+		return withCode(CodeStorageFailed, err)
+	}
+}
+
+// GetRestaurantWithMenu returns the restaurant and its menu.
+func (h *Handler) GetRestaurantWithMenu(ctx context.Context, id uint64) (*models.RestaurantWithMenuItems, error) {
+	restaurant, err := h.pg.GetRestaurant(ctx, id)
+	if err != nil {
+		return nil, crudError(err, "restaurant")
+	}
+	if restaurant == nil {
+		return nil, crudError(ErrNotFound, "restaurant")
+	}
+
+	menu, err := h.pg.GetMenu(ctx, id)
+	if err != nil {
+		return nil, crudError(err, "restaurant")
+	}
+
+	return &models.RestaurantWithMenuItems{Restaurant: *restaurant, MenuItems: menu}, nil
+}
+
+func (h *Handler) UpdateRestaurant(ctx context.Context, id uint64, update *RestaurantUpdate, partial bool) (*models.Restaurant, error) {
+	if err := update.Validate(partial); err != nil {
+		return nil, err
+	}
+
+	if err := h.pg.UpdateRestaurant(ctx, id, *update.UpdatedAt, update.Updates(partial)); err != nil {
+		return nil, crudError(err, "restaurant")
+	}
+
+	restaurant, err := h.pg.GetRestaurant(ctx, id)
+	if err != nil {
+		return nil, crudError(err, "restaurant")
+	}
+	if restaurant == nil {
+		return nil, crudError(ErrNotFound, "restaurant")
+	}
+
+	return restaurant, nil
+}
+
+// DeleteRestaurant deletes the restaurant with its menu and opening hours if it still has the given version.
+func (h *Handler) DeleteRestaurant(ctx context.Context, id uint64, version time.Time) error {
+	if err := h.pg.DeleteRestaurant(ctx, id, version); err != nil {
+		return crudError(err, "restaurant")
+	}
+
+	return nil
+}
+
+func (h *Handler) ListMenuItems(ctx context.Context, restaurantID uint64) ([]models.MenuItem, error) {
+	restaurant, err := h.GetRestaurantWithMenu(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	if restaurant.MenuItems == nil {
+		return []models.MenuItem{}, nil
+	}
+
+	return restaurant.MenuItems, nil
+}
+
+func (h *Handler) GetMenuItem(ctx context.Context, restaurantID, id uint64) (*models.MenuItem, error) {
+	item, err := h.pg.GetMenuItem(ctx, restaurantID, id)
+	if err != nil {
+		return nil, crudError(err, "menu item")
+	}
+	if item == nil {
+		return nil, crudError(ErrNotFound, "menu item")
+	}
+
+	return item, nil
+}
+
+func (h *Handler) CreateMenuItem(ctx context.Context, restaurantID uint64, create *MenuItemUpdate) (*models.MenuItem, error) {
+	if err := create.Validate(false, true); err != nil {
+		return nil, err
+	}
+
+	item := create.ToModel(restaurantID)
+	if err := h.pg.CreateMenuItem(ctx, &item); err != nil {
+		return nil, crudError(err, "restaurant")
+	}
+
+	return h.GetMenuItem(ctx, restaurantID, item.ID)
+}
+
+func (h *Handler) UpdateMenuItem(ctx context.Context, restaurantID, id uint64, update *MenuItemUpdate, partial bool) (*models.MenuItem, error) {
+	if err := update.Validate(partial, false); err != nil {
+		return nil, err
+	}
+
+	if err := h.pg.UpdateMenuItem(ctx, restaurantID, id, *update.UpdatedAt, update.Updates(partial)); err != nil {
+		return nil, crudError(err, "menu item")
+	}
+
+	return h.GetMenuItem(ctx, restaurantID, id)
+}
+
+func (h *Handler) DeleteMenuItem(ctx context.Context, restaurantID, id uint64, version time.Time) error {
+	if err := h.pg.DeleteMenuItem(ctx, restaurantID, id, version); err != nil {
+		return crudError(err, "menu item")
+	}
+
+	return nil
+}
+
+// lockVersion locks the row matched by the query and checks that it still has the version being edited. A nil
+// version only checks that the row exists.
+func lockVersion(tx *gorm.DB, model interface{}, version *time.Time, query string, args ...interface{}) error {
+	var versions []time.Time
+	if err := tx.Model(model).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(query, args...).
+		Pluck("updated_at", &versions).Error; err != nil {
+		return fmt.Errorf("failed to lock row: %w", err)
+	}
+	if len(versions) == 0 {
+		return ErrNotFound
+	}
+	if version != nil && !versions[0].Equal(*version) {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// UpdateRestaurant writes the columns if the restaurant still has the given version. The new updated_at no
+// longer matches embedded_at, so the CDC listener publishes the edit: the embedder recomputes the vector while
+// the previous one keeps serving, and the search caches drop the restaurant.
+func (s *Pg) UpdateRestaurant(ctx context.Context, id uint64, version time.Time, updates map[string]interface{}) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockVersion(tx, &models.Restaurant{}, &version, "id = ?", id); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Restaurant{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update restaurant: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (s *Pg) DeleteRestaurant(ctx context.Context, id uint64, version time.Time) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockVersion(tx, &models.Restaurant{}, &version, "id = ?", id); err != nil {
+			return err
+		}
+
+		if err := tx.Where("id = ?", id).Delete(&models.Restaurant{}).Error; err != nil {
+			return fmt.Errorf("failed to delete restaurant: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// GetMenuItem returns the menu item of the restaurant, or nil when it does not exist.
+func (s *Pg) GetMenuItem(ctx context.Context, restaurantID, id uint64) (*models.MenuItem, error) {
+	var items []models.MenuItem
+	if err := s.db.WithContext(ctx).Where("id = ? AND restaurant_id = ?", id, restaurantID).Limit(1).Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch menu item: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	return &items[0], nil
+}
+
+func (s *Pg) CreateMenuItem(ctx context.Context, item *models.MenuItem) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The restaurant is locked so that it cannot be deleted before the item is created.
+		if err := lockVersion(tx, &models.Restaurant{}, nil, "id = ?", item.RestaurantID); err != nil {
+			return err
+		}
+
+		if err := tx.Create(item).Error; err != nil {
+			return fmt.Errorf("failed to create menu item: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// UpdateMenuItem writes the columns if the menu item still has the given version.
+func (s *Pg) UpdateMenuItem(ctx context.Context, restaurantID, id uint64, version time.Time, updates map[string]interface{}) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockVersion(tx, &models.MenuItem{}, &version, "id = ? AND restaurant_id = ?", id, restaurantID); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.MenuItem{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update menu item: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (s *Pg) DeleteMenuItem(ctx context.Context, restaurantID, id uint64, version time.Time) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockVersion(tx, &models.MenuItem{}, &version, "id = ? AND restaurant_id = ?", id, restaurantID); err != nil {
+			return err
+		}
+
+		if err := tx.Where("id = ?", id).Delete(&models.MenuItem{}).Error; err != nil {
+			return fmt.Errorf("failed to delete menu item: %w", err)
+		}
+
+		return nil
+	})
+}
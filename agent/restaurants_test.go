@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func text(v string) *string {
+	return &v
+}
+
+// invalidFields returns the sorted fields of a validation error, and fails on errors that are not field errors.
+func invalidFields(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+	if code := errorCode(err); code != CodeInvalidRequest {
+		t.Errorf("error code = %q, want %q", code, CodeInvalidRequest)
+	}
+
+	var fields FieldErrors
+	if !errors.As(err, &fields) {
+		t.Fatalf("error %v has no field errors", err)
+	}
+
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func TestRestaurantUpdateValidate(t *testing.T) {
+	updatedAt := time.Now()
+	full := func() RestaurantUpdate {
+		return RestaurantUpdate{
+			Name:      text("Pizza Place"),
+			Area:      text("Marina"),
+			Rating:    float(4.5),
+			Location:  &GeoPoint{Lat: 25.2, Long: 55.3},
+			UpdatedAt: &updatedAt,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		update     func() RestaurantUpdate
+		partial    bool
+		wantFields []string
+	}{
+		{name: "full update", update: full},
+		{name: "full update with a timezone", update: func() RestaurantUpdate {
+			u := full()
+			u.Timezone = text("UTC")
+			return u
+		}},
+		{name: "partial update", update: func() RestaurantUpdate {
+			return RestaurantUpdate{Rating: float(3), UpdatedAt: &updatedAt}
+		}, partial: true},
+		{name: "missing version", update: func() RestaurantUpdate {
+			u := full()
+			u.UpdatedAt = nil
+			return u
+		}, wantFields: []string{"updated_at"}},
+		{name: "missing version of a partial update", update: func() RestaurantUpdate {
+			return RestaurantUpdate{Rating: float(3)}
+		}, partial: true, wantFields: []string{"updated_at"}},
+		{name: "missing required fields", update: func() RestaurantUpdate {
+			return RestaurantUpdate{UpdatedAt: &updatedAt}
+		}, wantFields: []string{"area", "location", "name", "rating"}},
+		{name: "empty name and area", update: func() RestaurantUpdate {
+			return RestaurantUpdate{Name: text(" "), Area: text(""), UpdatedAt: &updatedAt}
+		}, partial: true, wantFields: []string{"area", "name"}},
+		{name: "rating out of range", update: func() RestaurantUpdate {
+			u := full()
+			u.Rating = float(0.5)
+			return u
+		}, wantFields: []string{"rating"}},
+		{name: "location out of range", update: func() RestaurantUpdate {
+			u := full()
+			u.Location = &GeoPoint{Lat: 91, Long: -181}
+			return u
+		}, wantFields: []string{"location.lat", "location.long"}},
+		{name: "unknown timezone", update: func() RestaurantUpdate {
+			return RestaurantUpdate{Timezone: text("Mars/Olympus"), UpdatedAt: &updatedAt}
+		}, partial: true, wantFields: []string{"timezone"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update := tt.update()
+			if got := invalidFields(t, update.Validate(tt.partial)); !slices.Equal(got, tt.wantFields) {
+				t.Errorf("invalid fields = %q, want %q", got, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestMenuItemUpdateValidate(t *testing.T) {
+	updatedAt := time.Now()
+
+	tests := []struct {
+		name       string
+		update     MenuItemUpdate
+		partial    bool
+		create     bool
+		wantFields []string
+	}{
+		{
+			name:   "create",
+			update: MenuItemUpdate{Name: text("Margherita"), Description: text("Tomato and mozzarella"), Price: float(35)},
+			create: true,
+		},
+		{
+			name:   "full update",
+			update: MenuItemUpdate{Name: text("Margherita"), Description: text("Tomato and mozzarella"), Price: float(35), UpdatedAt: &updatedAt},
+		},
+		{
+			name:    "partial update",
+			update:  MenuItemUpdate{Price: float(38), UpdatedAt: &updatedAt},
+			partial: true,
+		},
+		{
+			name:       "missing version",
+			update:     MenuItemUpdate{Name: text("Margherita"), Description: text("Tomato and mozzarella"), Price: float(35)},
+			wantFields: []string{"updated_at"},
+		},
+		{
+			name:       "missing version of a partial update",
+			update:     MenuItemUpdate{Price: float(38)},
+			partial:    true,
+			wantFields: []string{"updated_at"},
+		},
+		{
+			name:       "missing required fields",
+			update:     MenuItemUpdate{Category: text("Pizza")},
+			create:     true,
+			wantFields: []string{"description", "name", "price"},
+		},
+		{
+			name:       "empty fields",
+			update:     MenuItemUpdate{Name: text(""), Description: text("  "), UpdatedAt: &updatedAt},
+			partial:    true,
+			wantFields: []string{"description", "name"},
+		},
+		{
+			name:       "price not positive",
+			update:     MenuItemUpdate{Price: float(0), UpdatedAt: &updatedAt},
+			partial:    true,
+			wantFields: []string{"price"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := invalidFields(t, tt.update.Validate(tt.partial, tt.create)); !slices.Equal(got, tt.wantFields) {
+				t.Errorf("invalid fields = %q, want %q", got, tt.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Parser sources reported per field in ParsedInput.Sources.
+const (
+	ParserRules = "rules"
+	ParserLLM   = "llm"
+)
+
+var (
+	// "within 2km", "500 m", "1.5 miles away"; a unit is required so budgets like "under 20" are left alone.
+	distancePattern = regexp.MustCompile(
+		`(?i)\b(?:(?:within|in|under|less than|up to|max(?:imum)?)\s+)?(\d+(?:\.\d+)?)\s*(km|kilomet(?:er|re)s?|m|met(?:er|re)s?|mi|miles?)\b(?:\s+away)?`,
+	)
+	// "4.5 stars", "4+ stars", "rated 4", "rating above 4.5".
+	ratingPattern = regexp.MustCompile(
+		`(?i)\b(?:(?:at least|min(?:imum)?|over|above)\s+)?(\d(?:\.\d+)?)\s*\+?\s*stars?\b|\brat(?:ed|ing)\s+(?:of\s+|above\s+|over\s+|at least\s+)?(\d(?:\.\d+)?)\s*\+?`,
+	)
+	highRatingPattern = regexp.MustCompile(`(?i)\b(?:highly|well|top|best)[\s-]rated\b|\bhigh(?:ly)? rating\b`)
+	proximityPattern  = regexp.MustCompile(`(?i)\b(?:nearby|near me|near here|close by|close to me|around me|around here|walking distance)\b`)
+)
+
+// ParseRules extracts the distance and rating of a query with fixed patterns. It never fails, fields it cannot
+// find are left nil and the query keeps everything that was not matched.
+func ParseRules(input string) *ParsedInput {
+	parsed := &ParsedInput{Sources: map[string]string{}}
+	query := input
+
+	if m := distancePattern.FindStringSubmatchIndex(query); m != nil {
+		value, _ := strconv.ParseFloat(query[m[2]:m[3]], 64)
+		if meters := toMeters(value, query[m[4]:m[5]]); meters > 0 {
+			parsed.Distance = &meters
+			parsed.Sources["distance"] = ParserRules
+			query = query[:m[0]] + query[m[1]:]
+		}
+	}
+	if parsed.Distance == nil {
+		if m := proximityPattern.FindStringIndex(query); m != nil {
+			meters := float64(DefaultNearbyDistance)
+			parsed.Distance = &meters
+			parsed.Sources["distance"] = ParserRules
+			query = query[:m[0]] + query[m[1]:]
+		}
+	}
+
+	if m := ratingPattern.FindStringSubmatchIndex(query); m != nil {
+		group := 2
+		if m[group] < 0 {
+			group = 4
+		}
+		rating, _ := strconv.ParseFloat(query[m[group]:m[group+1]], 64)
+		if rating >= 1 && rating <= 5 {
+			parsed.Rating = &rating
+			parsed.Sources["rating"] = ParserRules
+			query = query[:m[0]] + query[m[1]:]
+		}
+	}
+	if parsed.Rating == nil {
+		if m := highRatingPattern.FindStringIndex(query); m != nil {
+			rating := float64(DefaultHighRating)
+			parsed.Rating = &rating
+			parsed.Sources["rating"] = ParserRules
+			query = query[:m[0]] + query[m[1]:]
+		}
+	}
+
+	parsed.Query = strings.Join(strings.Fields(query), " ")
+	parsed.Sources["query"] = ParserRules
+	// The rule based query is the input minus the matched terms, it is as reliable as the input itself.
+	parsed.Confidence = 1
+
+	return parsed
+}
+
+func toMeters(value float64, unit string) float64 {
+	switch unit = strings.ToLower(unit); {
+	case unit == "m" || strings.HasPrefix(unit, "met"):
+		return value
+	case unit == "mi" || strings.HasPrefix(unit, "mile"):
+		return value * 1609.34
+	default:
+		return value * 1000
+	}
+}
+
+// mergeLLM fills the fields the rules did not find with the parser model output. The model's cleaned query
+// replaces the rule based one since it also drops the terms of the fields only the model understands, but only
+// when its confidence reaches minQueryConfidence or the rules left no query. Confidence is the one of the query.
+func (p *ParsedInput) mergeLLM(llm *ParsedInput, minQueryConfidence float64) {
+	if p.Distance == nil && llm.Distance != nil {
+		p.Distance = llm.Distance
+		p.Sources["distance"] = ParserLLM
+	}
+	if p.Rating == nil && llm.Rating != nil {
+		p.Rating = llm.Rating
+		p.Sources["rating"] = ParserLLM
+	}
+	if strings.TrimSpace(llm.Query) != "" && (llm.Confidence >= minQueryConfidence || strings.TrimSpace(p.Query) == "") {
+		p.Query = llm.Query
+		p.Sources["query"] = ParserLLM
+		p.Confidence = llm.Confidence
+	}
+
+	p.MinPrice = llm.MinPrice
+	p.MaxPrice = llm.MaxPrice
+	p.PriceTier = llm.PriceTier
+	p.Sort = llm.Sort
+	p.Badges = llm.Badges
+	p.OpenNow = llm.OpenNow
+	p.OpenAt = llm.OpenAt
+	p.Place = llm.Place
+	p.Paraphrases = llm.Paraphrases
+
+	for field, set := range map[string]bool{
+		"min_price":   llm.MinPrice != nil,
+		"max_price":   llm.MaxPrice != nil,
+		"price_tier":  llm.PriceTier != "",
+		"sort":        llm.Sort != "",
+		"badges":      len(llm.Badges) > 0,
+		"open_now":    llm.OpenNow,
+		"open_at":     llm.OpenAt != "",
+		"place":       llm.Place != "",
+		"paraphrases": len(llm.Paraphrases) > 0,
+	} {
+		if set {
+			p.Sources[field] = ParserLLM
+		}
+	}
+}
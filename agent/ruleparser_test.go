@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func equalFloat(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return math.Abs(*a-*b) < 1e-6
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		input        string
+		wantQuery    string
+		wantDistance *float64
+		wantRating   *float64
+	}{
+		{"pizza", "pizza", nil, nil},
+		{"pizza within 2km", "pizza", float(2000), nil},
+		{"sushi 500 m away", "sushi", float(500), nil},
+		{"burgers within 1.5 miles", "burgers", float(1.5 * 1609.34), nil},
+		{"shawarma nearby", "shawarma", float(DefaultNearbyDistance), nil},
+		{"pasta near me 3km", "pasta near me", float(3000), nil},
+		{"pizza under 20", "pizza under 20", nil, nil},
+		{"4.5 stars ramen", "ramen", nil, float(4.5)},
+		{"ramen rated 4+", "ramen", nil, float(4)},
+		{"ramen rating above 3.5", "ramen", nil, float(3.5)},
+		{"highly rated tacos", "tacos", nil, float(DefaultHighRating)},
+		{"tacos 9 stars", "tacos 9 stars", nil, nil},
+		{"top-rated kebab within 800m", "kebab", float(800), float(DefaultHighRating)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := ParseRules(tt.input)
+
+			if got.Query != tt.wantQuery {
+				t.Errorf("query = %q, want %q", got.Query, tt.wantQuery)
+			}
+			if !equalFloat(got.Distance, tt.wantDistance) {
+				t.Errorf("distance = %v, want %v", got.Distance, tt.wantDistance)
+			}
+			if !equalFloat(got.Rating, tt.wantRating) {
+				t.Errorf("rating = %v, want %v", got.Rating, tt.wantRating)
+			}
+			if got.Confidence != 1 {
+				t.Errorf("confidence = %v, want 1", got.Confidence)
+			}
+			if got.Sources["query"] != ParserRules {
+				t.Errorf("query source = %q, want %q", got.Sources["query"], ParserRules)
+			}
+			if (got.Sources["distance"] == ParserRules) != (tt.wantDistance != nil) {
+				t.Errorf("distance source = %q", got.Sources["distance"])
+			}
+			if (got.Sources["rating"] == ParserRules) != (tt.wantRating != nil) {
+				t.Errorf("rating source = %q", got.Sources["rating"])
+			}
+		})
+	}
+}
+
+func TestMergeLLM(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		llm            ParsedInput
+		wantQuery      string
+		wantConfidence float64
+		wantDistance   *float64
+		wantRating     *float64
+		wantSources    map[string]string
+	}{
+		{
+			name:           "confident model query replaces the rule query",
+			input:          "cheap pizza within 2km",
+			llm:            ParsedInput{Query: "pizza", MaxPrice: float(30), Confidence: 0.9},
+			wantQuery:      "pizza",
+			wantConfidence: 0.9,
+			wantDistance:   float(2000),
+			wantSources:    map[string]string{"query": ParserLLM, "distance": ParserRules, "max_price": ParserLLM},
+		},
+		{
+			name:           "low confidence keeps the rule query",
+			input:          "cheap pizza within 2km",
+			llm:            ParsedInput{Query: "something else", Confidence: 0.2},
+			wantQuery:      "cheap pizza",
+			wantConfidence: 1,
+			wantDistance:   float(2000),
+			wantSources:    map[string]string{"query": ParserRules, "distance": ParserRules},
+		},
+		{
+			name:           "empty model query keeps the rule query",
+			input:          "cheap pizza",
+			llm:            ParsedInput{Confidence: 0.9},
+			wantQuery:      "cheap pizza",
+			wantConfidence: 1,
+			wantSources:    map[string]string{"query": ParserRules},
+		},
+		{
+			name:           "empty rule query takes the model query at any confidence",
+			input:          "nearby",
+			llm:            ParsedInput{Query: "restaurants", Confidence: 0.2},
+			wantQuery:      "restaurants",
+			wantConfidence: 0.2,
+			wantDistance:   float(DefaultNearbyDistance),
+			wantSources:    map[string]string{"query": ParserLLM, "distance": ParserRules},
+		},
+		{
+			name:           "rule fields win over the model",
+			input:          "sushi within 2km rated 4",
+			llm:            ParsedInput{Query: "sushi", Distance: float(5000), Rating: float(3), Confidence: 0.9},
+			wantQuery:      "sushi",
+			wantConfidence: 0.9,
+			wantDistance:   float(2000),
+			wantRating:     float(4),
+			wantSources:    map[string]string{"query": ParserLLM, "distance": ParserRules, "rating": ParserRules},
+		},
+		{
+			name:           "model fills the missing fields",
+			input:          "vegan sushi in marina",
+			llm:            ParsedInput{Query: "sushi", Distance: float(5000), Rating: float(3), Badges: []string{"vegan"}, Place: "marina", Confidence: 0.8},
+			wantQuery:      "sushi",
+			wantConfidence: 0.8,
+			wantDistance:   float(5000),
+			wantRating:     float(3),
+			wantSources: map[string]string{
+				"query": ParserLLM, "distance": ParserLLM, "rating": ParserLLM, "badges": ParserLLM, "place": ParserLLM,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseRules(tt.input)
+			got.mergeLLM(&tt.llm, 0.5)
+
+			if got.Query != tt.wantQuery {
+				t.Errorf("query = %q, want %q", got.Query, tt.wantQuery)
+			}
+			if got.Confidence != tt.wantConfidence {
+				t.Errorf("confidence = %v, want %v", got.Confidence, tt.wantConfidence)
+			}
+			if !equalFloat(got.Distance, tt.wantDistance) {
+				t.Errorf("distance = %v, want %v", got.Distance, tt.wantDistance)
+			}
+			if !equalFloat(got.Rating, tt.wantRating) {
+				t.Errorf("rating = %v, want %v", got.Rating, tt.wantRating)
+			}
+			if len(got.Sources) != len(tt.wantSources) {
+				t.Errorf("sources = %v, want %v", got.Sources, tt.wantSources)
+			}
+			for field, want := range tt.wantSources {
+				if got.Sources[field] != want {
+					t.Errorf("source of %s = %q, want %q", field, got.Sources[field], want)
+				}
+			}
+		})
+	}
+}
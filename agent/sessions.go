@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkonsowa/restaurants-rag/config"
+	"github.com/tmc/langchaingo/chains"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/memory"
+	"github.com/tmc/langchaingo/memory/sqlite3"
+	"github.com/tmc/langchaingo/schema"
+)
+
+const (
+	SessionCookie = "session_id"
+	SessionHeader = "X-Session-ID"
+
+	sessionContextKey = "session_id"
+	sessionCookieAge  = 30 * 24 * 60 * 60
+
+	DefaultSessionRetention     = 30 * 24 * time.Hour
+	DefaultSessionPurgeInterval = time.Hour
+)
+
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// SessionStore keeps one conversation memory per client session on top of the sqlite chat history.
+type SessionStore struct {
+	db  *sql.DB
+	llm llms.Model
+}
+
+type SessionInfo struct {
+	ID           string    `json:"id"`
+	MessageCount int       `json:"message_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type SessionMessage struct {
+	Type      string    `json:"type"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewSessionStore(db *sql.DB, llm llms.Model) *SessionStore {
+	// Creating a history runs the table schema, so the queries below work before the first conversation.
+	sqlite3.NewSqliteChatMessageHistory(sqlite3.WithDB(db))
+
+	return &SessionStore{
+		db:  db,
+		llm: llm,
+	}
+}
+
+func (s *SessionStore) history(sessionID string) *sqlite3.SqliteChatMessageHistory {
+	return sqlite3.NewSqliteChatMessageHistory(
+		sqlite3.WithSession(sessionID),
+		sqlite3.WithDB(s.db),
+	)
+}
+
+// Chain returns a conversation chain whose memory is scoped to the session.
+func (s *SessionStore) Chain(sessionID string) *chains.LLMChain {
+	chain := chains.NewConversation(s.llm, s.Memory(sessionID))
+
+	return &chain
+}
+
+// Memory returns the conversation memory of the session, used by chains that manage their own model. Model
+// reasoning is left out of the saved answers.
+func (s *SessionStore) Memory(sessionID string) schema.Memory {
+	return answerMemory{memory.NewConversationBuffer(memory.WithChatHistory(s.history(sessionID)))}
+}
+
+func (s *SessionStore) List(ctx context.Context) ([]SessionInfo, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT session, COUNT(*), MIN(created), MAX(created) FROM %s GROUP BY session ORDER BY MAX(created) DESC",
+		sqlite3.DefaultTableName,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []SessionInfo{}
+	for rows.Next() {
+		var (
+			info                 SessionInfo
+			createdAt, updatedAt string
+		)
+		if err := rows.Scan(&info.ID, &info.MessageCount, &createdAt, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		// Aggregates lose the TIMESTAMP column type, so sqlite returns them as text.
+		info.CreatedAt, _ = time.Parse(time.DateTime, createdAt)
+		info.UpdatedAt, _ = time.Parse(time.DateTime, updatedAt)
+		sessions = append(sessions, info)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (s *SessionStore) Messages(ctx context.Context, sessionID string) ([]SessionMessage, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT type, content, created FROM %s WHERE session = ? ORDER BY id",
+		sqlite3.DefaultTableName,
+	), sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch session messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []SessionMessage{}
+	for rows.Next() {
+		var msg SessionMessage
+		if err := rows.Scan(&msg.Type, &msg.Content, &msg.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+func (s *SessionStore) Delete(ctx context.Context, sessionID string) error {
+	if err := s.history(sessionID).Clear(ctx); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	return nil
+}
+
+// Purge deletes the sessions without any message newer than the retention period.
+func (s *SessionStore) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %[1]s WHERE session IN (SELECT session FROM %[1]s GROUP BY session HAVING MAX(created) < datetime('now', ?))",
+		sqlite3.DefaultTableName,
+	), fmt.Sprintf("-%d seconds", int64(retention.Seconds())))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge sessions: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// RunRetention purges old sessions every interval until the context is cancelled.
+func (s *SessionStore) RunRetention(ctx context.Context, retention, interval time.Duration) {
+	if retention <= 0 {
+		retention = DefaultSessionRetention
+	}
+	if interval <= 0 {
+		interval = DefaultSessionPurgeInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := s.Purge(ctx, retention)
+		if err != nil {
+			slog.Error("failed to purge chat sessions", "error", err)
+		} else if deleted > 0 {
+			slog.Info("purged chat sessions", "messages", deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sessionMiddleware assigns every client a session. The session token of the X-Session-ID header or the
+// session cookie is only accepted when it was signed with the secret, otherwise a new session is created: a
+// client cannot pick the ID of someone else's session.
+func sessionMiddleware(secret []byte) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.GetHeader(SessionHeader)
+		if token == "" {
+			token, _ = ctx.Cookie(SessionCookie)
+		}
+
+		sessionID, ok := verifySessionToken(secret, token)
+		if !ok {
+			sessionID = randomID()
+			token = signSessionToken(secret, sessionID)
+		}
+
+		ctx.Set(sessionContextKey, sessionID)
+		ctx.Header(SessionHeader, token)
+		ctx.SetSameSite(http.SameSiteLaxMode)
+		ctx.SetCookie(SessionCookie, token, sessionCookieAge, "/", "", false, true)
+
+		ctx.Next()
+	}
+}
+
+// sessionSecret returns the configured signing key, or a random one that invalidates the sessions on restart.
+func sessionSecret(cfg config.Sessions) []byte {
+	if cfg.Secret != "" {
+		return []byte(cfg.Secret)
+	}
+
+	slog.Warn("sessions.secret is not set, sessions will not survive a restart")
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+
+	return secret
+}
+
+// signSessionToken returns the token handed to the client: the session ID and its HMAC.
+func signSessionToken(secret []byte, sessionID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(sessionID))
+
+	return sessionID + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func verifySessionToken(secret []byte, token string) (string, bool) {
+	sessionID, _, found := strings.Cut(token, ".")
+	if !found || !sessionIDPattern.MatchString(sessionID) {
+		return "", false
+	}
+
+	return sessionID, hmac.Equal([]byte(token), []byte(signSessionToken(secret, sessionID)))
+}
+
+// adminAuth only lets requests with the admin bearer token through.
+func adminAuth(token string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		given, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "admin token required"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+func sessionFromContext(ctx *gin.Context) string {
+	return ctx.GetString(sessionContextKey)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// Thinking modes of the summary config.
+const (
+	ThinkingDrop   = "drop"   // reasoning is discarded
+	ThinkingStream = "stream" // reasoning is sent as thinking events
+)
+
+var thinkBlockPattern = regexp.MustCompile(`(?s)<think>.*?(?:</think>|$)`)
+
+// stripThinking removes the reasoning blocks of a complete model answer.
+func stripThinking(text string) string {
+	return strings.TrimSpace(thinkBlockPattern.ReplaceAllString(text, ""))
+}
+
+// ThinkFilter splits a streamed model answer into reasoning and answer text. Tags split across chunks are held
+// back until the next chunk tells whether they are tags.
+type ThinkFilter struct {
+	onAnswer   func(text string) error
+	onThinking func(text string) error // nil drops the reasoning
+
+	thinking    bool
+	answerStart bool // whether answer text was sent, leading blank lines after a reasoning block are skipped
+	pending     string
+	answer      strings.Builder
+}
+
+func NewThinkFilter(onAnswer, onThinking func(text string) error) *ThinkFilter {
+	return &ThinkFilter{onAnswer: onAnswer, onThinking: onThinking}
+}
+
+func (f *ThinkFilter) Write(chunk []byte) error {
+	text := f.pending + string(chunk)
+	f.pending = ""
+
+	for text != "" {
+		tag := thinkOpenTag
+		if f.thinking {
+			tag = thinkCloseTag
+		}
+
+		if i := strings.Index(text, tag); i >= 0 {
+			if err := f.emit(text[:i]); err != nil {
+				return err
+			}
+			f.thinking = !f.thinking
+			text = text[i+len(tag):]
+			continue
+		}
+
+		// Hold back a tail that may be the start of the tag.
+		keep := partialSuffix(text, tag)
+		if err := f.emit(text[:len(text)-keep]); err != nil {
+			return err
+		}
+		f.pending = text[len(text)-keep:]
+		break
+	}
+
+	return nil
+}
+
+// Flush sends the text held back at the end of the stream.
+func (f *ThinkFilter) Flush() error {
+	text := f.pending
+	f.pending = ""
+
+	return f.emit(text)
+}
+
+// Answer returns the answer text sent so far.
+func (f *ThinkFilter) Answer() string {
+	return f.answer.String()
+}
+
+func (f *ThinkFilter) emit(text string) error {
+	if text == "" {
+		return nil
+	}
+
+	if f.thinking {
+		if f.onThinking == nil {
+			return nil
+		}
+		return f.onThinking(text)
+	}
+
+	if !f.answerStart {
+		text = strings.TrimLeft(text, " \t\r\n")
+		if text == "" {
+			return nil
+		}
+		f.answerStart = true
+	}
+	f.answer.WriteString(text)
+
+	return f.onAnswer(text)
+}
+
+// partialSuffix returns the length of the longest suffix of text that is a prefix of tag.
+func partialSuffix(text, tag string) int {
+	for n := min(len(text), len(tag)-1); n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+
+	return 0
+}
+
+// answerMemory stores only the answer of model outputs, so that reasoning is never replayed as history.
+type answerMemory struct {
+	schema.Memory
+}
+
+func (m answerMemory) SaveContext(ctx context.Context, inputs map[string]any, outputs map[string]any) error {
+	cleaned := make(map[string]any, len(outputs))
+	for key, value := range outputs {
+		if text, ok := value.(string); ok {
+			value = stripThinking(text)
+		}
+		cleaned[key] = value
+	}
+
+	return m.Memory.SaveContext(ctx, inputs, cleaned)
+}
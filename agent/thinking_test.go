@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestThinkFilter(t *testing.T) {
+	tests := []struct {
+		name         string
+		chunks       []string
+		stream       bool
+		wantAnswer   string
+		wantThinking string
+	}{
+		{
+			name:       "no reasoning",
+			chunks:     []string{"Try ", "Pizza Place."},
+			wantAnswer: "Try Pizza Place.",
+		},
+		{
+			name:       "reasoning is dropped",
+			chunks:     []string{"<think>they want pizza</think>\n\nTry Pizza Place."},
+			wantAnswer: "Try Pizza Place.",
+		},
+		{
+			name:         "reasoning is streamed",
+			chunks:       []string{"<think>they want pizza</think>\n\nTry Pizza Place."},
+			stream:       true,
+			wantAnswer:   "Try Pizza Place.",
+			wantThinking: "they want pizza",
+		},
+		{
+			name:         "tags split across chunks",
+			chunks:       []string{"<th", "ink>they want", " pizza</", "think>", "Try Pizza Place."},
+			stream:       true,
+			wantAnswer:   "Try Pizza Place.",
+			wantThinking: "they want pizza",
+		},
+		{
+			name:       "partial tag that is not a tag",
+			chunks:     []string{"a <", "b> c <thi", "s"},
+			wantAnswer: "a <b> c <this",
+		},
+		{
+			name:       "answer ending in a partial tag is flushed",
+			chunks:     []string{"Try Pizza Place <th"},
+			wantAnswer: "Try Pizza Place <th",
+		},
+		{
+			name:         "unclosed reasoning",
+			chunks:       []string{"<think>still thinking"},
+			stream:       true,
+			wantThinking: "still thinking",
+		},
+		{
+			name:       "leading whitespace of the answer only",
+			chunks:     []string{"  \n", "Try\n\n", "Pizza"},
+			wantAnswer: "Try\n\nPizza",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var answer, thinking string
+			onAnswer := func(text string) error {
+				answer += text
+				return nil
+			}
+			var onThinking func(string) error
+			if tt.stream {
+				onThinking = func(text string) error {
+					thinking += text
+					return nil
+				}
+			}
+
+			filter := NewThinkFilter(onAnswer, onThinking)
+			for _, chunk := range tt.chunks {
+				if err := filter.Write([]byte(chunk)); err != nil {
+					t.Fatalf("Write(%q) error = %v", chunk, err)
+				}
+			}
+			if err := filter.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+
+			if answer != tt.wantAnswer {
+				t.Errorf("answer = %q, want %q", answer, tt.wantAnswer)
+			}
+			if filter.Answer() != tt.wantAnswer {
+				t.Errorf("Answer() = %q, want %q", filter.Answer(), tt.wantAnswer)
+			}
+			if thinking != tt.wantThinking {
+				t.Errorf("thinking = %q, want %q", thinking, tt.wantThinking)
+			}
+		})
+	}
+}
+
+func TestPartialSuffix(t *testing.T) {
+	tests := []struct {
+		text string
+		tag  string
+		want int
+	}{
+		{"", thinkOpenTag, 0},
+		{"hello", thinkOpenTag, 0},
+		{"hello <", thinkOpenTag, 1},
+		{"hello <thin", thinkOpenTag, 5},
+		{"hello <think>", thinkOpenTag, 0},
+		{"<th", thinkOpenTag, 3},
+		{"a </thi", thinkCloseTag, 5},
+		{"a <thi", thinkCloseTag, 0},
+		{"a <", thinkCloseTag, 1},
+	}
+
+	for _, tt := range tests {
+		if got := partialSuffix(tt.text, tt.tag); got != tt.want {
+			t.Errorf("partialSuffix(%q, %q) = %d, want %d", tt.text, tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestStripThinking(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Try Pizza Place.", "Try Pizza Place."},
+		{"<think>they want pizza</think>\nTry Pizza Place.", "Try Pizza Place."},
+		{"<think>\nfirst\n</think>a<think>second</think> b", "a b"},
+		{"answer <think>unclosed", "answer"},
+	}
+
+	for _, tt := range tests {
+		if got := stripThinking(tt.text); got != tt.want {
+			t.Errorf("stripThinking(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/imkonsowa/restaurants-rag/models"
+	"github.com/tmc/langchaingo/callbacks"
+	"github.com/tmc/langchaingo/tools"
+)
+
+const defaultToolResultLimit = 5
+
+// agentTool adapts a function to the langchaingo tool interface. The function returns the observation for
+// the model, which is sent back as JSON, and an error only when the agent cannot continue.
+type agentTool struct {
+	name        string
+	description string
+	call        func(ctx context.Context, input string) (interface{}, error)
+
+	CallbacksHandler callbacks.Handler
+}
+
+var _ tools.Tool = (*agentTool)(nil)
+
+func (t *agentTool) Name() string {
+	return t.name
+}
+
+func (t *agentTool) Description() string {
+	return t.description
+}
+
+func (t *agentTool) Call(ctx context.Context, input string) (string, error) {
+	if t.CallbacksHandler != nil {
+		t.CallbacksHandler.HandleToolStart(ctx, input)
+	}
+
+	observation, err := t.call(ctx, strings.TrimSpace(input))
+	if err != nil {
+		if t.CallbacksHandler != nil {
+			t.CallbacksHandler.HandleToolError(ctx, err)
+		}
+		return "", err
+	}
+
+	output, ok := observation.(string)
+	if !ok {
+		data, err := json.Marshal(observation)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode %s result: %w", t.name, err)
+		}
+		output = string(data)
+	}
+
+	if t.CallbacksHandler != nil {
+		t.CallbacksHandler.HandleToolEnd(ctx, output)
+	}
+
+	return output, nil
+}
+
+// toolRestaurant is the compact restaurant view returned to the model, it leaves out embeddings and locations.
+type toolRestaurant struct {
+	ID        uint64     `json:"id"`
+	Name      string     `json:"name"`
+	LocalName string     `json:"local_name,omitempty"`
+	Area      string     `json:"area"`
+	Rating    float64    `json:"rating"`
+	Badges    []string   `json:"badges,omitempty"`
+	PriceTier string     `json:"price_tier,omitempty"`
+	OpenNow   *bool      `json:"open_now,omitempty"`
+	Distance  *float64   `json:"distance_m,omitempty"`
+	Items     []toolItem `json:"items,omitempty"`
+}
+
+type toolItem struct {
+	ID          uint64  `json:"id"`
+	Name        string  `json:"name"`
+	LocalName   string  `json:"local_name,omitempty"`
+	Category    string  `json:"category,omitempty"`
+	Price       float64 `json:"price"`
+	Description string  `json:"description,omitempty"`
+}
+
+func newToolRestaurant(r models.Restaurant) toolRestaurant {
+	return toolRestaurant{
+		ID:        r.ID,
+		Name:      r.Name,
+		LocalName: r.LocalName,
+		Area:      r.Area,
+		Rating:    r.Rating,
+		Badges:    r.Badges,
+		PriceTier: r.PriceTier,
+		OpenNow:   r.OpenNow,
+	}
+}
+
+func newToolItems(items []models.MenuItem) []toolItem {
+	result := make([]toolItem, 0, len(items))
+	for _, item := range items {
+		result = append(result, toolItem{
+			ID:          item.ID,
+			Name:        item.Name,
+			LocalName:   item.LocalName,
+			Category:    item.Category,
+			Price:       item.Price,
+			Description: item.Description,
+		})
+	}
+
+	return result
+}
+
+type searchToolInput struct {
+	Query       string    `json:"query"`
+	MaxDistance *float64  `json:"max_distance"`
+	MinRating   *float64  `json:"min_rating"`
+	MaxPrice    *float64  `json:"max_price"`
+	Badges      []string  `json:"badges"`
+	Sort        SortOrder `json:"sort"`
+	OpenNow     bool      `json:"open_now"`
+	OpenAt      string    `json:"open_at"`
+	Place       string    `json:"place"`
+	Limit       int       `json:"limit"`
+}
+
+// agentTools returns the tools available to the agent, searches are made around the user location.
+func (h *Handler) agentTools(location *GeoPoint, handler callbacks.Handler) []tools.Tool {
+	return []tools.Tool{
+		&agentTool{
+			name: "search_restaurants",
+			description: `Searches restaurants by dish, cuisine or restaurant type. Input is a JSON object like ` +
+				`{"query": "sushi", "max_distance": 5000, "min_rating": 4, "max_price": 50, "badges": ["vegan"], ` +
+				`"sort": "relevance|distance|rating|price", "open_now": true, "open_at": "23:00", "place": "Zamalek", "limit": 5}, ` +
+				`only "query" is required. Returns restaurants with their ids and the matching menu items.`,
+			call: func(ctx context.Context, input string) (interface{}, error) {
+				return h.searchTool(ctx, input, location)
+			},
+			CallbacksHandler: handler,
+		},
+		&agentTool{
+			name:        "get_menu",
+			description: `Returns the full menu of a restaurant with item names, categories and prices. Input is the restaurant id.`,
+			call: func(ctx context.Context, input string) (interface{}, error) {
+				id, err := parseToolRestaurantID(input)
+				if err != nil {
+					return err.Error(), nil
+				}
+
+				items, err := h.pg.GetMenu(ctx, id)
+				if err != nil {
+					return nil, err
+				}
+				if len(items) == 0 {
+					return fmt.Sprintf("restaurant %d has no menu items", id), nil
+				}
+
+				return newToolItems(items), nil
+			},
+			CallbacksHandler: handler,
+		},
+		&agentTool{
+			name:        "get_restaurant",
+			description: `Returns the details of a restaurant: name, area, rating, badges and price tier. Input is the restaurant id.`,
+			call: func(ctx context.Context, input string) (interface{}, error) {
+				id, err := parseToolRestaurantID(input)
+				if err != nil {
+					return err.Error(), nil
+				}
+
+				restaurant, err := h.pg.GetRestaurant(ctx, id)
+				if err != nil {
+					return nil, err
+				}
+				if restaurant == nil {
+					return fmt.Sprintf("restaurant %d does not exist", id), nil
+				}
+
+				return newToolRestaurant(*restaurant), nil
+			},
+			CallbacksHandler: handler,
+		},
+		&agentTool{
+			name:        "list_areas",
+			description: `Lists the areas that have restaurants and how many restaurants each one has. Takes no input.`,
+			call: func(ctx context.Context, _ string) (interface{}, error) {
+				return h.pg.ListAreas(ctx)
+			},
+			CallbacksHandler: handler,
+		},
+	}
+}
+
+func (h *Handler) searchTool(ctx context.Context, input string, location *GeoPoint) (interface{}, error) {
+	var params searchToolInput
+	if err := json.Unmarshal([]byte(input), &params); err != nil {
+		// Models often pass the plain query instead of the JSON object.
+		params = searchToolInput{Query: strings.Trim(input, `"'`)}
+	}
+	params.Query = strings.TrimSpace(params.Query)
+	if params.Query == "" {
+		return "query is required", nil
+	}
+
+	sortOrder, err := ParseSortOrder(string(params.Sort))
+	if err != nil {
+		return err.Error(), nil
+	}
+
+	filter := h.defaultFilter(location)
+	filter.Sort = sortOrder
+	filter.Limit = defaultToolResultLimit
+	if params.Limit > 0 {
+		filter.Limit = min(params.Limit, MaxResultLimit)
+	}
+	if params.MaxDistance != nil {
+		filter.MaxDistance = *params.MaxDistance
+	}
+	if params.MinRating != nil {
+		filter.MinRating = *params.MinRating
+	}
+	if params.OpenAt != "" && !validClock(params.OpenAt) {
+		return "open_at must be a HH:MM time", nil
+	}
+	filter.MaxPrice = params.MaxPrice
+	filter.Badges = h.badges.Normalize(params.Badges)
+	filter.OpenNow = params.OpenNow
+	filter.OpenAt = params.OpenAt
+	if params.Place != "" {
+		if _, err := h.applyPlace(ctx, &filter, params.Place, params.MaxDistance != nil); err != nil {
+			return nil, err
+		}
+		if filter.Place == "" {
+			return fmt.Sprintf("unknown place %q, search without the place or ask the user where it is", params.Place), nil
+		}
+	}
+
+	queryVectors, err := h.embedQueries(ctx, []string{params.Query})
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := h.pg.Search(ctx, params.Query, queryVectors, filter)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	if len(results) == 0 {
+		return "no restaurants match the search", nil
+	}
+
+	restaurants := make([]toolRestaurant, 0, len(results))
+	for _, r := range results {
+		restaurant := newToolRestaurant(r.Restaurant)
+		restaurant.Distance = r.Distance
+		restaurant.Items = newToolItems(r.MenuItems)
+		restaurants = append(restaurants, restaurant)
+	}
+
+	return restaurants, nil
+}
+
+// parseToolRestaurantID accepts a bare id, a quoted id or a {"restaurant_id": id} object.
+func parseToolRestaurantID(input string) (uint64, error) {
+	var params struct {
+		RestaurantID uint64 `json:"restaurant_id"`
+	}
+	if err := json.Unmarshal([]byte(input), &params); err == nil && params.RestaurantID != 0 {
+		return params.RestaurantID, nil
+	}
+
+	id, err := strconv.ParseUint(strings.Trim(input, "\"' "), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid restaurant id %q, pass the numeric id", input)
+	}
+
+	return id, nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/imkonsowa/restaurants-rag/models"
 )
@@ -11,31 +13,171 @@ const (
 	DefaultHighRating     = 4     // for "highly rated"
 	DefaultMaxDistance    = 20000 // 5km default max
 	DefaultMinRating      = 3     // default minimum rating
+	DefaultResultLimit    = 10    // restaurants returned per search
+	MaxResultLimit        = 50    // upper bound for per-request top_k
 )
 
+const (
+	PriceTierLow  = "$"
+	PriceTierMid  = "$$"
+	PriceTierHigh = "$$$"
+)
+
+type SearchMode string
+
+const (
+	SearchModeVector  SearchMode = "vector"
+	SearchModeKeyword SearchMode = "keyword"
+	SearchModeHybrid  SearchMode = "hybrid"
+)
+
+func ParseSearchMode(mode string) (SearchMode, error) {
+	switch m := SearchMode(strings.ToLower(strings.TrimSpace(mode))); m {
+	case "":
+		return "", nil
+	case SearchModeVector, SearchModeKeyword, SearchModeHybrid:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid search mode %q, expected one of vector, keyword or hybrid", mode)
+	}
+}
+
+type SortOrder string
+
+const (
+	SortByRelevance SortOrder = "relevance"
+	SortByDistance  SortOrder = "distance"
+	SortByRating    SortOrder = "rating"
+	SortByPrice     SortOrder = "price"
+)
+
+func ParseSortOrder(order string) (SortOrder, error) {
+	switch o := SortOrder(strings.ToLower(strings.TrimSpace(order))); o {
+	case "":
+		return "", nil
+	case SortByRelevance, SortByDistance, SortByRating, SortByPrice:
+		return o, nil
+	default:
+		return "", fmt.Errorf("invalid sort %q, expected one of relevance, distance, rating or price", order)
+	}
+}
+
+// SearchResult is a restaurant with its matched menu items and the values it was ranked by.
+type SearchResult struct {
+	models.RestaurantWithMenuItems
+	Distance   *float64 `json:"distance,omitempty"` // meters from the user location, nil without a location
+	Similarity float64  `json:"similarity"`         // best cosine similarity of the restaurant or its items
+	Score      float64  `json:"score"`              // blended relevance score
+
+	Explanation *Explanation `json:"explanation,omitempty"`
+}
+
+// SearchOptions are per-request overrides of the search configuration, bound from the query string or the request body.
+type SearchOptions struct {
+	Mode                SearchMode `form:"mode" json:"mode"`
+	Sort                SortOrder  `form:"sort" json:"sort"`
+	SimilarityThreshold *float64   `form:"threshold" json:"threshold"`
+	TopK                *int       `form:"top_k" json:"top_k"`
+	ItemsPerRestaurant  *int       `form:"items_per_restaurant" json:"items_per_restaurant"`
+	Agent               bool       `form:"agent" json:"agent"` // answer with the tool-calling agent instead of the search pipeline
+}
+
+// Validate checks the overrides and normalises the search mode and sort.
+func (o *SearchOptions) Validate() error {
+	mode, err := ParseSearchMode(string(o.Mode))
+	if err != nil {
+		return err
+	}
+	o.Mode = mode
+
+	sortOrder, err := ParseSortOrder(string(o.Sort))
+	if err != nil {
+		return err
+	}
+	o.Sort = sortOrder
+
+	if o.SimilarityThreshold != nil && (*o.SimilarityThreshold < 0 || *o.SimilarityThreshold > 1) {
+		return fmt.Errorf("threshold must be between 0 and 1")
+	}
+	if o.TopK != nil && (*o.TopK < 1 || *o.TopK > MaxResultLimit) {
+		return fmt.Errorf("top_k must be between 1 and %d", MaxResultLimit)
+	}
+	if o.ItemsPerRestaurant != nil && (*o.ItemsPerRestaurant < 0 || *o.ItemsPerRestaurant > MaxResultLimit) {
+		return fmt.Errorf("items_per_restaurant must be between 0 and %d", MaxResultLimit)
+	}
+
+	return nil
+}
+
+// Apply sets the overrides on the filter.
+func (o *SearchOptions) Apply(filter *SearchFilter) {
+	filter.Mode = o.Mode
+	if o.Sort != "" {
+		filter.Sort = o.Sort
+	}
+	if o.SimilarityThreshold != nil {
+		filter.SimilarityThreshold = *o.SimilarityThreshold
+	}
+	if o.TopK != nil {
+		filter.Limit = *o.TopK
+	}
+	if o.ItemsPerRestaurant != nil {
+		filter.ItemsPerRestaurant = *o.ItemsPerRestaurant
+	}
+}
+
 type GeoPoint struct {
 	Lat  float64 `json:"lat"`
 	Long float64 `json:"long"`
 }
 
+// ParseGeoPoint parses a location from query string values, it returns nil when either value is missing.
+func ParseGeoPoint(latitude, longitude string) (*GeoPoint, error) {
+	if longitude == "" || latitude == "" {
+		return nil, nil
+	}
+
+	var (
+		point GeoPoint
+		err   error
+	)
+
+	point.Lat, err = strconv.ParseFloat(latitude, 64)
+	if err != nil {
+		return nil, withCode(CodeInvalidLocation, fmt.Errorf("invalid latitude"))
+	}
+
+	point.Long, err = strconv.ParseFloat(longitude, 64)
+	if err != nil {
+		return nil, withCode(CodeInvalidLocation, fmt.Errorf("invalid longitude"))
+	}
+
+	return &point, nil
+}
+
 type ProcessingResult struct {
 	Err error
-	Msg WebSocketsMessage
+	Msg Event
 }
 
 type CreateRestaurantsRequest struct {
 	Restaurants []struct {
 		Name      string   `json:"name"`
+		LocalName string   `json:"local_name"` // optional, e.g. the Arabic name
 		Area      string   `json:"area"`
 		Location  GeoPoint `json:"location"`
 		Rating    float64  `json:"rating"`
 		Badges    []string `json:"badges"`
 		MenuItems []struct {
 			Name        string  `json:"name"`
+			LocalName   string  `json:"local_name"`
 			Category    string  `json:"category"`
 			Description string  `json:"description"`
 			Price       float64 `json:"price"`
 		} `json:"menu_items"`
+		Timezone     string             `json:"timezone"` // IANA name, UTC when empty
+		OpeningHours []OpeningPeriod    `json:"opening_hours"`
+		Exceptions   []OpeningException `json:"opening_hours_exceptions"`
 	}
 }
 
@@ -53,6 +195,19 @@ func (c *CreateRestaurantsRequest) Validate() error {
 				return fmt.Errorf("menu item name, description, and price are required")
 			}
 		}
+		if err := validateTimezone(r.Timezone); err != nil {
+			return err
+		}
+		for _, p := range r.OpeningHours {
+			if err := p.Validate(); err != nil {
+				return fmt.Errorf("restaurant %s: %w", r.Name, err)
+			}
+		}
+		for _, e := range r.Exceptions {
+			if err := e.Validate(); err != nil {
+				return fmt.Errorf("restaurant %s: %w", r.Name, err)
+			}
+		}
 	}
 
 	return nil
@@ -63,18 +218,31 @@ func (c *CreateRestaurantsRequest) ToModels() []models.RestaurantWithMenuItems {
 	for i, r := range c.Restaurants {
 		restaurants[i] = models.RestaurantWithMenuItems{
 			Restaurant: models.Restaurant{
-				Name:     r.Name,
-				Area:     r.Area,
-				Badges:   r.Badges,
-				Location: models.NewGeoPoint(r.Location.Lat, r.Location.Long),
-				Rating:   r.Rating,
+				Name:      r.Name,
+				LocalName: r.LocalName,
+				Area:      r.Area,
+				Badges:    r.Badges,
+				Location:  models.NewGeoPoint(r.Location.Lat, r.Location.Long),
+				Rating:    r.Rating,
+				Timezone:  r.Timezone,
 			},
 			MenuItems: make([]models.MenuItem, len(r.MenuItems)),
 		}
+		if restaurants[i].Restaurant.Timezone == "" {
+			restaurants[i].Restaurant.Timezone = DefaultTimezone
+		}
+
+		for _, p := range r.OpeningHours {
+			restaurants[i].OpeningHours = append(restaurants[i].OpeningHours, p.ToModel())
+		}
+		for _, e := range r.Exceptions {
+			restaurants[i].Exceptions = append(restaurants[i].Exceptions, e.ToModel())
+		}
 
 		for j, m := range r.MenuItems {
 			restaurants[i].MenuItems[j] = models.MenuItem{
 				Name:        m.Name,
+				LocalName:   m.LocalName,
 				Description: m.Description,
 				Price:       m.Price,
 				Category:    m.Category,
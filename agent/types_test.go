@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func integer(v int) *int {
+	return &v
+}
+
+func TestSearchOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  SearchOptions
+		wantErr  bool
+		wantMode SearchMode
+		wantSort SortOrder
+	}{
+		{name: "empty", options: SearchOptions{}},
+		{
+			name:     "mode and sort are normalised",
+			options:  SearchOptions{Mode: " Hybrid ", Sort: "RATING"},
+			wantMode: SearchModeHybrid,
+			wantSort: SortByRating,
+		},
+		{
+			name:    "bounds",
+			options: SearchOptions{SimilarityThreshold: float(1), TopK: integer(MaxResultLimit), ItemsPerRestaurant: integer(0)},
+		},
+		{name: "unknown mode", options: SearchOptions{Mode: "fuzzy"}, wantErr: true},
+		{name: "unknown sort", options: SearchOptions{Sort: "popularity"}, wantErr: true},
+		{name: "negative threshold", options: SearchOptions{SimilarityThreshold: float(-0.1)}, wantErr: true},
+		{name: "threshold above 1", options: SearchOptions{SimilarityThreshold: float(1.1)}, wantErr: true},
+		{name: "zero top_k", options: SearchOptions{TopK: integer(0)}, wantErr: true},
+		{name: "top_k above the limit", options: SearchOptions{TopK: integer(MaxResultLimit + 1)}, wantErr: true},
+		{name: "negative items_per_restaurant", options: SearchOptions{ItemsPerRestaurant: integer(-1)}, wantErr: true},
+		{name: "items_per_restaurant above the limit", options: SearchOptions{ItemsPerRestaurant: integer(MaxResultLimit + 1)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.options.Mode != tt.wantMode || tt.options.Sort != tt.wantSort {
+				t.Errorf("mode and sort = %q, %q, want %q, %q", tt.options.Mode, tt.options.Sort, tt.wantMode, tt.wantSort)
+			}
+		})
+	}
+}
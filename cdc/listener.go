@@ -18,6 +18,9 @@ import (
 
 const outputPlugin = "wal2json"
 
+// KindEmbedded is the kind of the published changes that only stored the embedder's vector of a row.
+const KindEmbedded = "embedded"
+
 type WAL2JSONMessage struct {
 	Change []WAL2JSONChange `json:"change"`
 }
@@ -27,6 +30,12 @@ type WAL2JSONChange struct {
 	Table        string        `json:"table"`
 	ColumnNames  []string      `json:"columnnames,omitempty"`
 	ColumnValues []interface{} `json:"columnvalues,omitempty"`
+	OldKeys      *WAL2JSONKeys `json:"oldkeys,omitempty"` // primary key of deleted rows
+}
+
+type WAL2JSONKeys struct {
+	KeyNames  []string      `json:"keynames"`
+	KeyValues []interface{} `json:"keyvalues"`
 }
 
 type Listener struct {
@@ -173,12 +182,14 @@ func (l *Listener) processChanges(changes []WAL2JSONChange) {
 	}
 
 	for _, change := range changes {
-		if change.Kind != "insert" && change.Kind != "update" {
+		if change.Kind != "insert" && change.Kind != "update" && change.Kind != "delete" {
 			continue
 		}
 
-		if change.Kind == "update" && hasEmbedding(change) {
-			continue
+		// Vector updates of the embedder are published as embedded so that they are not embedded again in a loop.
+		kind := change.Kind
+		if kind == "update" && writtenByEmbedder(change) {
+			kind = KindEmbedded
 		}
 
 		subject, ok := tableSubjects[change.Table]
@@ -193,7 +204,7 @@ func (l *Listener) processChanges(changes []WAL2JSONChange) {
 
 		data, _ := json.Marshal(map[string]interface{}{
 			"table": change.Table,
-			"kind":  change.Kind,
+			"kind":  kind,
 			"id":    id,
 		})
 
@@ -282,19 +293,38 @@ func (l *Listener) sendStandbyStatus(ctx context.Context) error {
 	})
 }
 
-func hasEmbedding(change WAL2JSONChange) bool {
+// writtenByEmbedder reports whether an update only stored the vector of the row. The embedder sets embedded_at
+// to the updated_at of the version it embedded, while edits move updated_at past it. Tables without the
+// columns fall back to whether the row has an embedding.
+func writtenByEmbedder(change WAL2JSONChange) bool {
+	embeddedAt, hasEmbeddedAt := columnValue(change, "embedded_at")
+	updatedAt, hasUpdatedAt := columnValue(change, "updated_at")
+	if hasEmbeddedAt && hasUpdatedAt {
+		return embeddedAt != nil && embeddedAt == updatedAt
+	}
+
+	embedding, _ := columnValue(change, "embedding")
+	return embedding != nil
+}
+
+func columnValue(change WAL2JSONChange, column string) (interface{}, bool) {
 	for i, name := range change.ColumnNames {
-		if name == "embedding" && i < len(change.ColumnValues) {
-			return change.ColumnValues[i] != nil
+		if name == column && i < len(change.ColumnValues) {
+			return change.ColumnValues[i], true
 		}
 	}
-	return false
+	return nil, false
 }
 
 func extractID(change WAL2JSONChange) uint64 {
-	for i, name := range change.ColumnNames {
-		if name == "id" && i < len(change.ColumnValues) {
-			if v, ok := change.ColumnValues[i].(float64); ok {
+	names, values := change.ColumnNames, change.ColumnValues
+	if change.Kind == "delete" && change.OldKeys != nil {
+		names, values = change.OldKeys.KeyNames, change.OldKeys.KeyValues
+	}
+
+	for i, name := range names {
+		if name == "id" && i < len(values) {
+			if v, ok := values[i].(float64); ok {
 				return uint64(v)
 			}
 		}
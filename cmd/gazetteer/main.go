@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/imkonsowa/restaurants-rag/config"
+	"github.com/lib/pq"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const defaultGazetteerFile = "platform/gazetteer/places.geojson"
+
+// Feature is a GeoJSON feature describing an area (polygon) or a landmark (point).
+type Feature struct {
+	Properties struct {
+		Name    string   `json:"name"`
+		Kind    string   `json:"kind"`
+		Aliases []string `json:"aliases"`
+	} `json:"properties"`
+	Geometry json.RawMessage `json:"geometry"`
+}
+
+type FeatureCollection struct {
+	Features []Feature `json:"features"`
+}
+
+// upsertPlace stores the geometry in the axis order of the restaurants location, where x is the latitude,
+// so GeoJSON coordinates are flipped.
+const upsertPlace = `
+INSERT INTO places (name, kind, aliases, geom)
+VALUES (?, ?, ?, ST_SetSRID(ST_FlipCoordinates(ST_GeomFromGeoJSON(?)), 4326))
+ON CONFLICT ((lower(name))) DO UPDATE SET kind    = EXCLUDED.kind,
+                                          aliases = EXCLUDED.aliases,
+                                          geom    = EXCLUDED.geom`
+
+func main() {
+	cfg := config.LoadConfig()
+
+	path := defaultGazetteerFile
+	if len(os.Args) > 1 {
+		path = os.Args[1]
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal("failed to read gazetteer file:", err)
+	}
+
+	var collection FeatureCollection
+	if err := json.Unmarshal(data, &collection); err != nil {
+		log.Fatal("failed to parse gazetteer file:", err)
+	}
+
+	db, err := gorm.Open(postgres.Open(cfg.Postgres.ConnStr()), &gorm.Config{})
+	if err != nil {
+		log.Fatal("failed to connect to postgres:", err)
+	}
+
+	var loaded int
+	for _, feature := range collection.Features {
+		name := strings.TrimSpace(feature.Properties.Name)
+		if name == "" || len(feature.Geometry) == 0 {
+			slog.Warn("skipping place without name or geometry", "name", name)
+			continue
+		}
+
+		kind := feature.Properties.Kind
+		if kind != "area" && kind != "landmark" {
+			slog.Warn("skipping place with unknown kind", "name", name, "kind", kind)
+			continue
+		}
+
+		aliases := feature.Properties.Aliases
+		if aliases == nil {
+			aliases = []string{}
+		}
+
+		if err := db.Exec(upsertPlace, name, kind, pq.StringArray(aliases), string(feature.Geometry)).Error; err != nil {
+			slog.Error("failed to load place", "name", name, "err", err)
+			continue
+		}
+		loaded++
+	}
+
+	slog.Info("gazetteer loaded", "places", loaded, "file", path)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -48,12 +49,25 @@ type Ollama struct {
 	EmbeddingModel string `mapstructure:"embeddingModel"`
 	ContextModel   string `mapstructure:"contextModel"`
 	ParserModel    string `mapstructure:"parserModel"`
+
+	EmbeddingProfile  string            `mapstructure:"embeddingProfile"`
+	EmbeddingProfiles map[string]string `mapstructure:"embeddingProfiles"` // profile name: embedding model
 }
 
 func (o *Ollama) Address() string {
 	return fmt.Sprintf("http://%s:%s", o.Host, o.Port)
 }
 
+// Embedder returns the embedding model of the selected profile, or EmbeddingModel when no profile is selected.
+// The agent and the embedder must use the same model.
+func (o *Ollama) Embedder() string {
+	if model, ok := o.EmbeddingProfiles[strings.ToLower(o.EmbeddingProfile)]; ok && model != "" {
+		return model
+	}
+
+	return o.EmbeddingModel
+}
+
 type Server struct {
 	Port int    `mapstructure:"port"`
 	Host string `mapstructure:"host"`
@@ -68,6 +82,106 @@ type Embedder struct {
 	QueueSize int `mapstructure:"queueSize"`
 }
 
+type Retrieval struct {
+	Mode          string  `mapstructure:"mode"`
+	Fusion        string  `mapstructure:"fusion"`
+	RRFK          float64 `mapstructure:"rrfK"`
+	VectorWeight  float64 `mapstructure:"vectorWeight"`
+	KeywordWeight float64 `mapstructure:"keywordWeight"`
+
+	VectorCandidates  int `mapstructure:"vectorCandidates"`
+	KeywordCandidates int `mapstructure:"keywordCandidates"`
+
+	RestaurantWeight float64 `mapstructure:"restaurantWeight"`
+	ItemWeight       float64 `mapstructure:"itemWeight"`
+
+	MinParseConfidence float64 `mapstructure:"minParseConfidence"`
+	MinLLMConfidence   float64 `mapstructure:"minLLMConfidence"`
+	QueryExpansion     bool    `mapstructure:"queryExpansion"`
+	MaxParaphrases     int     `mapstructure:"maxParaphrases"`
+}
+
+type Rerank struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	Candidates int           `mapstructure:"candidates"`
+	Timeout    time.Duration `mapstructure:"timeout"`
+}
+
+// Validate checks the weights, a weight of 0 disables its signal but every pair needs one enabled.
+func (r Retrieval) Validate() error {
+	for name, weight := range map[string]float64{
+		"vectorWeight":     r.VectorWeight,
+		"keywordWeight":    r.KeywordWeight,
+		"restaurantWeight": r.RestaurantWeight,
+		"itemWeight":       r.ItemWeight,
+	} {
+		if weight < 0 {
+			return fmt.Errorf("retrieval.%s must not be negative", name)
+		}
+	}
+	if r.VectorWeight+r.KeywordWeight == 0 {
+		return fmt.Errorf("retrieval.vectorWeight and retrieval.keywordWeight must not both be 0")
+	}
+	if r.RestaurantWeight+r.ItemWeight == 0 {
+		return fmt.Errorf("retrieval.restaurantWeight and retrieval.itemWeight must not both be 0")
+	}
+
+	return nil
+}
+
+type Badges struct {
+	Synonyms map[string][]string `mapstructure:"synonyms"`
+}
+
+type Search struct {
+	SimilarityThreshold float64 `mapstructure:"similarityThreshold"`
+	TopK                int     `mapstructure:"topK"`
+	ItemsPerRestaurant  int     `mapstructure:"itemsPerRestaurant"` // 0 returns every matching item
+
+	CursorTTL        time.Duration `mapstructure:"cursorTTL"`
+	CursorSecret     string        `mapstructure:"cursorSecret"`     // encrypts pagination cursors, a random key is used when empty
+	CursorEmbeddings int           `mapstructure:"cursorEmbeddings"` // query vectors kept for cursors
+}
+
+func (s Search) Validate() error {
+	if s.SimilarityThreshold < 0 || s.SimilarityThreshold > 1 {
+		return fmt.Errorf("search.similarityThreshold must be between 0 and 1")
+	}
+	if s.TopK < 1 {
+		return fmt.Errorf("search.topK must be at least 1")
+	}
+	if s.ItemsPerRestaurant < 0 {
+		return fmt.Errorf("search.itemsPerRestaurant must not be negative")
+	}
+
+	return nil
+}
+
+type Sessions struct {
+	Retention     time.Duration `mapstructure:"retention"`
+	PurgeInterval time.Duration `mapstructure:"purgeInterval"`
+	Secret        string        `mapstructure:"secret"`     // signs the session tokens, a random key is used when empty
+	AdminToken    string        `mapstructure:"adminToken"` // bearer token of the sessions admin endpoints, disabled when empty
+}
+
+type Summary struct {
+	Thinking string `mapstructure:"thinking"`
+	Grounded bool   `mapstructure:"grounded"`
+	Attempts int    `mapstructure:"attempts"`
+	Currency string `mapstructure:"currency"`
+}
+
+type Cache struct {
+	Enabled           bool          `mapstructure:"enabled"`
+	TTL               time.Duration `mapstructure:"ttl"`
+	MaxEntries        int           `mapstructure:"maxEntries"`
+	LocationPrecision int           `mapstructure:"locationPrecision"` // decimals of the location bucket
+}
+
+type Agent struct {
+	MaxIterations int `mapstructure:"maxIterations"`
+}
+
 type Config struct {
 	Postgres    Postgres    `mapstructure:"postgres"`
 	Nats        Nats        `mapstructure:"nats"`
@@ -75,6 +189,14 @@ type Config struct {
 	Replication Replication `mapstructure:"replication"`
 	Server      Server      `mapstructure:"server"`
 	Embedder    Embedder    `mapstructure:"embedder"`
+	Retrieval   Retrieval   `mapstructure:"retrieval"`
+	Rerank      Rerank      `mapstructure:"rerank"`
+	Badges      Badges      `mapstructure:"badges"`
+	Search      Search      `mapstructure:"search"`
+	Sessions    Sessions    `mapstructure:"sessions"`
+	Agent       Agent       `mapstructure:"agent"`
+	Cache       Cache       `mapstructure:"cache"`
+	Summary     Summary     `mapstructure:"summary"`
 }
 
 func LoadConfig() *Config {
@@ -82,6 +204,14 @@ func LoadConfig() *Config {
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
+	viper.SetDefault("search.similarityThreshold", 0.6)
+	viper.SetDefault("search.topK", 10)
+	viper.SetDefault("retrieval.vectorWeight", 1.0)
+	viper.SetDefault("retrieval.keywordWeight", 1.0)
+	viper.SetDefault("retrieval.restaurantWeight", 1.0)
+	viper.SetDefault("retrieval.itemWeight", 1.0)
+	viper.SetDefault("summary.currency", "AED")
+
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatal(err)
 	}
@@ -91,5 +221,12 @@ func LoadConfig() *Config {
 		log.Fatal(err)
 	}
 
+	if err := config.Search.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := config.Retrieval.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
 	return &config
 }
@@ -0,0 +1,31 @@
+package config
+
+import "testing"
+
+func TestRetrievalValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		retrieval Retrieval
+		wantErr   bool
+	}{
+		{"all weights", Retrieval{VectorWeight: 1, KeywordWeight: 1, RestaurantWeight: 0.4, ItemWeight: 0.6}, false},
+		{"keyword ranking disabled", Retrieval{VectorWeight: 1, RestaurantWeight: 0.4, ItemWeight: 0.6}, false},
+		{"vector ranking disabled", Retrieval{KeywordWeight: 1, RestaurantWeight: 0.4, ItemWeight: 0.6}, false},
+		{"restaurant tier disabled", Retrieval{VectorWeight: 1, KeywordWeight: 1, ItemWeight: 1}, false},
+		{"item tier disabled", Retrieval{VectorWeight: 1, KeywordWeight: 1, RestaurantWeight: 1}, false},
+		{"both rankings disabled", Retrieval{RestaurantWeight: 0.4, ItemWeight: 0.6}, true},
+		{"both tiers disabled", Retrieval{VectorWeight: 1, KeywordWeight: 1}, true},
+		{"negative vector weight", Retrieval{VectorWeight: -1, KeywordWeight: 1, RestaurantWeight: 1, ItemWeight: 1}, true},
+		{"negative keyword weight", Retrieval{VectorWeight: 1, KeywordWeight: -1, RestaurantWeight: 1, ItemWeight: 1}, true},
+		{"negative restaurant weight", Retrieval{VectorWeight: 1, KeywordWeight: 1, RestaurantWeight: -0.5, ItemWeight: 1}, true},
+		{"negative item weight", Retrieval{VectorWeight: 1, KeywordWeight: 1, RestaurantWeight: 1, ItemWeight: -0.5}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.retrieval.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
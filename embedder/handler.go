@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 
+	"github.com/imkonsowa/restaurants-rag/lang"
 	_ "github.com/lib/pq"
 	"github.com/pgvector/pgvector-go"
 	"github.com/tmc/langchaingo/llms/ollama"
@@ -24,7 +25,7 @@ func NewHandler(llm *ollama.LLM, pg *Pg) (*Handler, error) {
 }
 
 func (h *Handler) GenerateTextVector(ctx context.Context, text string) ([]float32, error) {
-	embeds, err := h.llm.CreateEmbedding(ctx, []string{text})
+	embeds, err := h.llm.CreateEmbedding(ctx, []string{lang.Normalize(text)})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create embedding: %w", err)
 	}
@@ -35,6 +36,12 @@ func (h *Handler) GenerateTextVector(ctx context.Context, text string) ([]float3
 	return embeds[0], nil
 }
 
+// nothingToEmbed reports whether a change leaves no text to embed: deleted rows and the vectors the embedder
+// stored itself, which the CDC listener publishes as embedded.
+func nothingToEmbed(data map[string]interface{}) bool {
+	return data["kind"] == "delete" || data["kind"] == "embedded"
+}
+
 // HandleRestaurantCDCMessage Updates restaurant vector in the database on receiving a cdc message from nats.
 func (h *Handler) HandleRestaurantCDCMessage(ctx context.Context, msg []byte) error {
 	var data map[string]interface{}
@@ -44,6 +51,10 @@ func (h *Handler) HandleRestaurantCDCMessage(ctx context.Context, msg []byte) er
 		return err
 	}
 
+	if nothingToEmbed(data) {
+		return nil
+	}
+
 	restaurantId := uint64(data["id"].(float64))
 
 	restaurant, err := h.pg.GetRestaurant(ctx, restaurantId)
@@ -53,10 +64,11 @@ func (h *Handler) HandleRestaurantCDCMessage(ctx context.Context, msg []byte) er
 
 	vector, err := h.GenerateTextVector(ctx, restaurant.Stringify())
 	if err != nil {
-		slog.Warn("Failed to generate restaurant vector", "err", err)
+		// The message is redelivered, the previous vector keeps serving meanwhile.
+		return fmt.Errorf("failed to generate restaurant vector: %w", err)
 	}
 
-	if err := h.pg.UpdateRestaurantVector(ctx, restaurantId, pgvector.NewVector(vector)); err != nil {
+	if err := h.pg.UpdateRestaurantVector(ctx, restaurantId, restaurant.UpdatedAt, pgvector.NewVector(vector)); err != nil {
 		slog.Warn("Failed to update restaurant vector", "err", err)
 	}
 
@@ -71,6 +83,10 @@ func (h *Handler) HandleMenuItemCDCMessage(ctx context.Context, msg []byte) erro
 		return err
 	}
 
+	if nothingToEmbed(data) {
+		return nil
+	}
+
 	menuItemId := uint64(data["id"].(float64))
 
 	menuItem, err := h.pg.GetMenuItem(ctx, menuItemId)
@@ -80,10 +96,10 @@ func (h *Handler) HandleMenuItemCDCMessage(ctx context.Context, msg []byte) erro
 
 	vector, err := h.GenerateTextVector(ctx, menuItem.Stringify())
 	if err != nil {
-		slog.Warn("Failed to generate menu item vector", "err", err)
+		return fmt.Errorf("failed to generate menu item vector: %w", err)
 	}
 
-	if err := h.pg.UpdateMenuItemVector(ctx, menuItemId, pgvector.NewVector(vector)); err != nil {
+	if err := h.pg.UpdateMenuItemVector(ctx, menuItemId, menuItem.UpdatedAt, pgvector.NewVector(vector)); err != nil {
 		slog.Warn("Failed to update menu item vector", "err", err)
 	}
 
@@ -98,6 +114,10 @@ func (h *Handler) HandleCategoryCDCMessage(ctx context.Context, msg []byte) erro
 		return err
 	}
 
+	if nothingToEmbed(data) {
+		return nil
+	}
+
 	categoryId := uint64(data["id"].(float64))
 
 	category, err := h.pg.GetCategory(ctx, categoryId)
@@ -31,7 +31,7 @@ func main() {
 	// Create a new Ollama instance of the embedding model
 	llm, err := ollama.New(
 		ollama.WithServerURL(cfg.Ollama.Address()),
-		ollama.WithModel(cfg.Ollama.EmbeddingModel),
+		ollama.WithModel(cfg.Ollama.Embedder()),
 	)
 	if err != nil {
 		log.Fatal(err)
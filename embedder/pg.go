@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"time"
 
 	"github.com/imkonsowa/restaurants-rag/models"
 	"github.com/pgvector/pgvector-go"
@@ -51,14 +52,22 @@ func (p *Pg) GetCategory(ctx context.Context, categoryId uint64) (*models.Catego
 	return &category, nil
 }
 
-func (p *Pg) UpdateRestaurantVector(ctx context.Context, restaurantId uint64, vector pgvector.Vector) error {
-	return p.db.WithContext(ctx).Model(&models.Restaurant{}).Where("id = ?", restaurantId).Update("embedding", vector).Error
+// UpdateRestaurantVector stores the vector of the given version of the restaurant. embedded_at is set to its
+// updated_at, which tells the CDC listener that the update comes from the embedder and not from an edit. A
+// restaurant edited since it was read is left alone, the event of the edit embeds it again.
+func (p *Pg) UpdateRestaurantVector(ctx context.Context, restaurantId uint64, version time.Time, vector pgvector.Vector) error {
+	return p.db.WithContext(ctx).Model(&models.Restaurant{}).
+		Where("id = ? AND updated_at = ?", restaurantId, version).
+		UpdateColumns(map[string]interface{}{"embedding": vector, "embedded_at": gorm.Expr("updated_at")}).Error
 }
 
-func (p *Pg) UpdateMenuItemVector(ctx context.Context, menuItemId uint64, vector pgvector.Vector) error {
-	return p.db.WithContext(ctx).Model(&models.MenuItem{}).Where("id = ?", menuItemId).Update("embedding", vector).Error
+// UpdateMenuItemVector stores the vector of the given version of the menu item, see UpdateRestaurantVector.
+func (p *Pg) UpdateMenuItemVector(ctx context.Context, menuItemId uint64, version time.Time, vector pgvector.Vector) error {
+	return p.db.WithContext(ctx).Model(&models.MenuItem{}).
+		Where("id = ? AND updated_at = ?", menuItemId, version).
+		UpdateColumns(map[string]interface{}{"embedding": vector, "embedded_at": gorm.Expr("updated_at")}).Error
 }
 
 func (p *Pg) UpdateCategoryVector(ctx context.Context, categoryId uint64, vector pgvector.Vector) error {
-	return p.db.WithContext(ctx).Model(&models.Category{}).Where("id = ?", categoryId).Update("embedding", vector).Error
+	return p.db.WithContext(ctx).Model(&models.Category{}).Where("id = ?", categoryId).UpdateColumn("embedding", vector).Error
 }
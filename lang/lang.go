@@ -0,0 +1,134 @@
+// Package lang detects the language of user queries and normalises Arabic text so that queries and documents
+// spelled with different letter variants embed and match alike.
+package lang
+
+import (
+	"strings"
+	"unicode"
+)
+
+type Language string
+
+const (
+	English Language = "en"
+	Arabic  Language = "ar"
+	Franco  Language = "ar-Latn" // Franco-Arabic, Egyptian Arabic written with Latin letters and digits
+)
+
+// Name returns the language name used in model prompts.
+func (l Language) Name() string {
+	switch l {
+	case Arabic:
+		return "Arabic"
+	case Franco:
+		return "Egyptian Arabic written in Latin letters and digits (Franco-Arabic)"
+	default:
+		return "English"
+	}
+}
+
+// francoWords are common Franco-Arabic words of restaurant queries that carry no digit.
+var francoWords = map[string]bool{
+	"ayez": true, "ayza": true, "fein": true, "feen": true, "fen": true, "ezay": true,
+	"akl": true, "matam": true, "orayeb": true, "olayel": true, "gamby": true, "gambi": true,
+	"kwayes": true, "kwayyes": true, "rekhis": true, "rkhees": true, "ghaly": true, "ghali": true,
+	"delwa2ty": true, "dlw2ty": true, "ya3ni": true, "bta3": true, "msh": true, "mesh": true,
+}
+
+// unitSuffixes are the letters that may follow a number in English queries, "2km" or "5pm" are not Franco-Arabic.
+var unitSuffixes = map[string]bool{
+	"k": true, "km": true, "m": true, "mi": true, "kg": true, "g": true, "am": true, "pm": true,
+	"h": true, "hr": true, "hrs": true, "min": true, "mins": true, "nd": true, "rd": true, "th": true, "st": true,
+	"egp": true, "le": true, "star": true, "stars": true,
+}
+
+// Detect guesses the language of a query. Text mostly in Arabic script is Arabic, Latin text using digits as
+// letters (3 for ain, 7 for hah, 2 for hamza...) or common Franco-Arabic words is Franco-Arabic.
+func Detect(text string) Language {
+	var arabic, latin int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Arabic, r) && unicode.IsLetter(r):
+			arabic++
+		case r < unicode.MaxASCII && unicode.IsLetter(r):
+			latin++
+		}
+	}
+	if arabic > 0 && arabic >= latin {
+		return Arabic
+	}
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if francoWords[word] || isFrancoWord(word) {
+			return Franco
+		}
+	}
+
+	return English
+}
+
+// isFrancoWord reports whether a word uses digits as letters: every digit is one Franco-Arabic uses as a letter
+// and is followed by a letter, either starting the word or following a letter, as in "3ayez" or "ya3ni". Digits
+// ending a word ("top5", "pizza2") and short words ("h2o") are not Franco-Arabic.
+func isFrancoWord(word string) bool {
+	runes := []rune(word)
+
+	var letters, francoDigits int
+	for i, r := range runes {
+		switch {
+		case unicode.IsLetter(r):
+			letters++
+		case !strings.ContainsRune("235789", r):
+			return false
+		case i+1 == len(runes) || !unicode.IsLetter(runes[i+1]):
+			return false
+		case i > 0 && !unicode.IsLetter(runes[i-1]):
+			return false
+		default:
+			francoDigits++
+		}
+	}
+	if francoDigits == 0 || letters < 3 {
+		return false
+	}
+
+	return !unitSuffixes[strings.TrimLeft(word, "0123456789")]
+}
+
+// Normalize unifies Arabic spelling variants: diacritics and tatweel are removed, alef variants become a bare
+// alef, alef maksura and Persian yeh become yeh and Arabic-Indic numbers become ASCII numbers. Other text is kept.
+func Normalize(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '\u064B' && r <= '\u065F', r == '\u0670', r == '\u0640': // harakat, superscript alef, tatweel
+			return -1
+		case r == '\u0623', r == '\u0625', r == '\u0622', r == '\u0671': // alef with hamza above, below, madda, wasla
+			return '\u0627'
+		case r == '\u0649', r == '\u06CC': // alef maksura, Persian yeh
+			return '\u064A'
+		case r >= '\u0660' && r <= '\u0669':
+			return '0' + (r - '\u0660')
+		case r >= '\u06F0' && r <= '\u06F9':
+			return '0' + (r - '\u06F0')
+		case r == '\u066B': // Arabic decimal separator
+			return '.'
+		default:
+			return r
+		}
+	}, text)
+}
+
+// NormalizeSQL wraps a SQL text expression with the translate() equivalent of Normalize, for documents compared
+// with normalised queries inside the database. The letters past the replacements of normalizeSQLTo are removed.
+func NormalizeSQL(expr string) string {
+	return "translate(" + expr + ", " + normalizeSQLFrom + ", " + normalizeSQLTo + ")"
+}
+
+const (
+	normalizeSQLFrom = `U&'\0623\0625\0622\0671\0649\06CC\0660\0661\0662\0663\0664\0665\0666\0667\0668\0669` +
+		`\06F0\06F1\06F2\06F3\06F4\06F5\06F6\06F7\06F8\06F9\066B` +
+		`\064B\064C\064D\064E\064F\0650\0651\0652\0653\0654\0655\0656\0657\0658\0659\065A\065B\065C\065D\065E\065F\0670\0640'`
+	normalizeSQLTo = `U&'\0627\0627\0627\0627\064A\064A01234567890123456789.'`
+)
@@ -0,0 +1,149 @@
+package lang
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		text string
+		want Language
+	}{
+		{"pizza near me", English},
+		{"", English},
+		{"عايز بيتزا", Arabic},
+		{"بيتزا pizza", Arabic},
+		{"3ayez pizza", Franco},
+		{"fein akl kwayes", Franco},
+		{"ya3ni eh el a7san", Franco},
+		{"within 2km", English},
+		{"open at 5pm", English},
+		{"top5 burgers", English},
+		{"h2o bar", English},
+		{"pizza2 go", English},
+		{"2nd floor cafe", English},
+		{"w3 burger", English},
+	}
+
+	for _, tt := range tests {
+		if got := Detect(tt.text); got != tt.want {
+			t.Errorf("Detect(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestIsFrancoWord(t *testing.T) {
+	tests := []struct {
+		word string
+		want bool
+	}{
+		{"3ayez", true},
+		{"7elw", true},
+		{"ba2a", true},
+		{"ya3ni", true},
+		{"delwa2ty", true},
+		{"top5", false},
+		{"h2o", false},
+		{"pizza2", false},
+		{"5mins", false},
+		{"3a", false},
+		{"1ayez", false},
+		{"2024", false},
+		{"b3d2", false},
+		{"pizza", false},
+	}
+
+	for _, tt := range tests {
+		if got := isFrancoWord(tt.word); got != tt.want {
+			t.Errorf("isFrancoWord(%q) = %v, want %v", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"pizza", "pizza"},
+		{"أحمد", "احمد"},
+		{"إسكندرية", "اسكندرية"},
+		{"آيس كريم", "ايس كريم"},
+		{"مستشفى", "مستشفي"},
+		{"شَاوَرْمَا", "شاورما"},
+		{"كبـــاب", "كباب"},
+		{"٢٥ درهم", "25 درهم"},
+		{"۴۵", "45"},
+		{"٤٫٥ نجوم", "4.5 نجوم"},
+	}
+
+	for _, tt := range tests {
+		if got := Normalize(tt.text); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestNormalizeSQLMatchesNormalize runs the translate() of NormalizeSQL over the Arabic block and ASCII, so that
+// documents normalised in the database match queries normalised in Go.
+func TestNormalizeSQLMatchesNormalize(t *testing.T) {
+	from, to := unicodeLiteral(t, normalizeSQLFrom), unicodeLiteral(t, normalizeSQLTo)
+	if len(to) > len(from) {
+		t.Fatalf("translate() has %d replacements for %d letters", len(to), len(from))
+	}
+
+	translate := func(r rune) string {
+		switch i := slices.Index(from, r); {
+		case i < 0:
+			return string(r)
+		case i < len(to):
+			return string(to[i])
+		default:
+			return ""
+		}
+	}
+
+	for r := rune(0x20); r <= 0x06FF; r++ {
+		if r > 0x7E && r < 0x0600 {
+			continue
+		}
+		if got, want := translate(r), Normalize(string(r)); got != want {
+			t.Errorf("translate(%U) = %q, Normalize = %q", r, got, want)
+		}
+	}
+}
+
+// unicodeLiteral decodes a U&'...' SQL string constant with \XXXX escapes.
+func unicodeLiteral(t *testing.T, literal string) []rune {
+	t.Helper()
+
+	body, ok := strings.CutPrefix(literal, "U&'")
+	if !ok || !strings.HasSuffix(body, "'") {
+		t.Fatalf("%q is not a U& string constant", literal)
+	}
+	body = strings.TrimSuffix(body, "'")
+
+	var runes []rune
+	for body != "" {
+		if body[0] != '\\' {
+			r := []rune(body)[0]
+			runes = append(runes, r)
+			body = body[len(string(r)):]
+			continue
+		}
+		if len(body) < 5 {
+			t.Fatalf("short escape %q", body)
+		}
+		code, err := strconv.ParseUint(body[1:5], 16, 32)
+		if err != nil {
+			t.Fatalf("bad escape %q: %v", body[:5], err)
+		}
+		runes = append(runes, rune(code))
+		body = body[5:]
+	}
+
+	return runes
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/lib/pq"
 	"github.com/pgvector/pgvector-go"
@@ -68,11 +69,17 @@ func (loc Location) GormValue(ctx context.Context, db *gorm.DB) clause.Expr {
 type Restaurant struct {
 	ID        uint64          `gorm:"primaryKey" json:"id"`
 	Name      string          `json:"name"`
+	LocalName string          `json:"local_name,omitempty"` // optional name in the local language, e.g. Arabic
 	Area      string          `json:"area"`
 	Rating    float64         `json:"rating"`
 	Badges    pq.StringArray  `gorm:"type:text[]" json:"badges"`
 	Location  Location        `json:"location"`
 	Embedding pgvector.Vector `gorm:"type:vector(768)" json:"-"`
+	Timezone  string          `gorm:"default:UTC" json:"timezone"` // IANA name, opening hours are in this timezone
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"` // version of the row, edits must send it back
+	PriceTier string          `gorm:"-" json:"price_tier,omitempty"`
+	OpenNow   *bool           `gorm:"-" json:"open_now,omitempty"` // nil when the restaurant has no opening hours
 }
 
 func (r *Restaurant) TableName() string {
@@ -80,7 +87,16 @@ func (r *Restaurant) TableName() string {
 }
 
 func (r *Restaurant) Stringify() string {
-	return fmt.Sprintf("Restaurant: %s, Area: %s, Rating: %.1f, Badges: %s", r.Name, r.Area, r.Rating, strings.Join(r.Badges, ", "))
+	return fmt.Sprintf("Restaurant: %s, Area: %s, Rating: %.1f, Badges: %s", withLocalName(r.Name, r.LocalName), r.Area, r.Rating, strings.Join(r.Badges, ", "))
+}
+
+// withLocalName appends the localised name so that both names are embedded and shown to the models.
+func withLocalName(name, localName string) string {
+	if localName == "" {
+		return name
+	}
+
+	return fmt.Sprintf("%s (%s)", name, localName)
 }
 
 type Category struct {
@@ -103,9 +119,12 @@ type MenuItem struct {
 	RestaurantID uint64          `json:"restaurant_id"`
 	Category     string          `json:"category"`
 	Name         string          `json:"name"`
+	LocalName    string          `json:"local_name,omitempty"`
 	Price        float64         `json:"price"`
 	Description  string          `json:"description"`
 	Embedding    pgvector.Vector `gorm:"type:vector(768)" json:"-"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
 }
 
 func (m *MenuItem) TableName() string {
@@ -113,10 +132,39 @@ func (m *MenuItem) TableName() string {
 }
 
 func (m *MenuItem) Stringify() string {
-	return fmt.Sprintf("MenuItem: %s, Category: %s, Price: %.2f, Description: %s", m.Name, m.Category, m.Price, m.Description)
+	return fmt.Sprintf("MenuItem: %s, Category: %s, Price: %.2f, Description: %s", withLocalName(m.Name, m.LocalName), m.Category, m.Price, m.Description)
+}
+
+// OpeningHours is one opening period of the weekly schedule, in the restaurant's local time.
+type OpeningHours struct {
+	ID           uint64 `gorm:"primaryKey" json:"id"`
+	RestaurantID uint64 `json:"restaurant_id"`
+	DayOfWeek    int    `json:"day_of_week"`             // 0 is Sunday
+	Opens        string `gorm:"type:time" json:"opens"`  // HH:MM
+	Closes       string `gorm:"type:time" json:"closes"` // HH:MM, at or before Opens when it closes after midnight
+}
+
+func (o *OpeningHours) TableName() string {
+	return "opening_hours"
+}
+
+// OpeningHoursException replaces the weekly schedule on a given date, e.g. a holiday.
+type OpeningHoursException struct {
+	ID           uint64  `gorm:"primaryKey" json:"id"`
+	RestaurantID uint64  `json:"restaurant_id"`
+	Date         string  `gorm:"type:date" json:"date"` // YYYY-MM-DD
+	Closed       bool    `json:"closed"`
+	Opens        *string `gorm:"type:time" json:"opens,omitempty"`
+	Closes       *string `gorm:"type:time" json:"closes,omitempty"`
+}
+
+func (o *OpeningHoursException) TableName() string {
+	return "opening_hour_exceptions"
 }
 
 type RestaurantWithMenuItems struct {
-	Restaurant Restaurant `json:"restaurant"`
-	MenuItems  []MenuItem `json:"menu_items,omitempty"`
+	Restaurant   Restaurant              `json:"restaurant"`
+	MenuItems    []MenuItem              `json:"menu_items,omitempty"`
+	OpeningHours []OpeningHours          `json:"opening_hours,omitempty"`
+	Exceptions   []OpeningHoursException `json:"opening_hours_exceptions,omitempty"`
 }
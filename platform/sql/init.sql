@@ -8,14 +8,18 @@ CREATE TABLE IF NOT EXISTS restaurants
 (
     id         SERIAL PRIMARY KEY,
     name       TEXT          NOT NULL,
+    local_name TEXT          NULL,
     area       TEXT          NOT NULL,
     rating     NUMERIC(3, 1) NOT NULL,
     badges     TEXT[]        NULL,
     embedding  vector(768)   NULL,
     location   GEOGRAPHY(POINT, 4326) NULL,
+    timezone   TEXT          NOT NULL DEFAULT 'UTC',
 
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+    created_at  TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    -- updated_at of the version the embedding was computed from, set by the embedder
+    embedded_at TIMESTAMP WITH TIME ZONE NULL
 );
 
 
@@ -24,15 +28,109 @@ CREATE TABLE IF NOT EXISTS menu_items
     id            SERIAL PRIMARY KEY,
     restaurant_id INTEGER REFERENCES restaurants ( id ) ON DELETE CASCADE,
     name          TEXT           NOT NULL,
+    local_name    TEXT           NULL,
     description   TEXT           NOT NULL,
     category      TEXT,
     price         NUMERIC(10, 2) NOT NULL,
     embedding     vector(768)    NULL,
 
     created_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    updated_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+    updated_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    embedded_at   TIMESTAMP WITH TIME ZONE NULL
 );
 
+-- Columns added since the tables were first created, databases created before them get them here.
+ALTER TABLE restaurants ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE restaurants ADD COLUMN IF NOT EXISTS local_name TEXT NULL;
+ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS local_name TEXT NULL;
+ALTER TABLE restaurants ADD COLUMN IF NOT EXISTS embedded_at TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS embedded_at TIMESTAMP WITH TIME ZONE NULL;
+
+-- Weekly opening hours in the restaurant's local time. A period whose closing time is at or before its
+-- opening time ends the next day.
+CREATE TABLE IF NOT EXISTS opening_hours
+(
+    id            SERIAL PRIMARY KEY,
+    restaurant_id INTEGER REFERENCES restaurants ( id ) ON DELETE CASCADE,
+    day_of_week   SMALLINT NOT NULL CHECK ( day_of_week BETWEEN 0 AND 6 ),
+    opens         TIME     NOT NULL,
+    closes        TIME     NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS opening_hours_restaurant_idx ON opening_hours ( restaurant_id, day_of_week );
+
+-- Exceptions replace the weekly opening hours of their date.
+CREATE TABLE IF NOT EXISTS opening_hour_exceptions
+(
+    id            SERIAL PRIMARY KEY,
+    restaurant_id INTEGER REFERENCES restaurants ( id ) ON DELETE CASCADE,
+    date          DATE    NOT NULL,
+    closed        BOOLEAN NOT NULL DEFAULT FALSE,
+    opens         TIME    NULL,
+    closes        TIME    NULL,
+
+    CHECK ( closed OR (opens IS NOT NULL AND closes IS NOT NULL) )
+);
+
+CREATE INDEX IF NOT EXISTS opening_hour_exceptions_restaurant_idx ON opening_hour_exceptions ( restaurant_id, date );
+
+-- restaurant_open_at tells whether a restaurant is open at an instant. The periods of the local day and those
+-- of the day before that run past midnight are checked, exceptions replace the weekly hours of their date.
+CREATE OR REPLACE FUNCTION restaurant_open_at(restaurant INTEGER, tz TEXT, at TIMESTAMPTZ) RETURNS BOOLEAN AS
+$$
+SELECT EXISTS (SELECT 1
+               FROM (SELECT (at AT TIME ZONE tz)::date - d AS day, (at AT TIME ZONE tz)::time AS t, d AS days_before
+                     FROM generate_series(0, 1) AS d) AS local
+                        CROSS JOIN LATERAL (SELECT e.opens, e.closes
+                                            FROM opening_hour_exceptions e
+                                            WHERE e.restaurant_id = restaurant
+                                              AND e.date = local.day
+                                              AND NOT e.closed
+                                            UNION ALL
+                                            SELECT h.opens, h.closes
+                                            FROM opening_hours h
+                                            WHERE h.restaurant_id = restaurant
+                                              AND h.day_of_week = EXTRACT(DOW FROM local.day)
+                                              AND NOT EXISTS (SELECT 1
+                                                              FROM opening_hour_exceptions e
+                                                              WHERE e.restaurant_id = restaurant
+                                                                AND e.date = local.day)) AS period
+               WHERE CASE local.days_before
+                         WHEN 0 THEN period.opens <= local.t AND (local.t < period.closes OR period.closes <= period.opens)
+                         ELSE period.closes <= period.opens AND local.t < period.closes
+                         END)
+$$ LANGUAGE sql STABLE;
+
+-- Gazetteer of areas (polygons) and landmarks (points) used to resolve place names in text-only queries.
+-- Geometries follow the restaurants location axis order, x is the latitude.
+CREATE TABLE IF NOT EXISTS places
+(
+    id      SERIAL PRIMARY KEY,
+    name    TEXT                     NOT NULL,
+    kind    TEXT                     NOT NULL CHECK ( kind IN ('area', 'landmark') ),
+    aliases TEXT[]                   NOT NULL DEFAULT '{}',
+    geom    GEOMETRY(GEOMETRY, 4326) NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS places_name_idx ON places ( lower(name) );
+
+CREATE INDEX IF NOT EXISTS places_geom_idx ON places USING gist ( geom );
+
+-- Restaurants are split into three price tiers ($, $$, $$$) by the median price of their menu.
+CREATE OR REPLACE VIEW restaurant_price_tiers AS
+WITH medians AS (SELECT restaurant_id,
+                        percentile_cont(0.5) WITHIN GROUP ( ORDER BY price ) AS median_price
+                 FROM menu_items
+                 GROUP BY restaurant_id)
+SELECT restaurant_id,
+       median_price,
+       CASE ntile(3) OVER ( ORDER BY median_price )
+           WHEN 1 THEN '$'
+           WHEN 2 THEN '$$'
+           ELSE '$$$'
+           END AS price_tier
+FROM medians;
+
 -- CREATE TABLE IF NOT EXISTS categories
 -- (
 --     id            SERIAL PRIMARY KEY,
@@ -54,6 +152,19 @@ CREATE INDEX IF NOT EXISTS menu_items_embedding_idx
     ON menu_items USING ivfflat ( embedding vector_cosine_ops )
     WITH (lists = 100);
 
+-- The translate() calls are lang.NormalizeSQL: they unify Arabic alef and yeh variants, turn Arabic-Indic digits into
+-- ASCII and remove harakat and tatweel. Keep them in sync with lang.Normalize and the search queries in agent/pg.go.
+CREATE INDEX IF NOT EXISTS menu_items_fts_idx
+    ON menu_items USING gin ( to_tsvector('english', translate(name || ' ' || coalesce(local_name, '') || ' ' || description,
+        U&'\0623\0625\0622\0671\0649\06CC\0660\0661\0662\0663\0664\0665\0666\0667\0668\0669\06F0\06F1\06F2\06F3\06F4\06F5\06F6\06F7\06F8\06F9\066B\064B\064C\064D\064E\064F\0650\0651\0652\0653\0654\0655\0656\0657\0658\0659\065A\065B\065C\065D\065E\065F\0670\0640',
+        U&'\0627\0627\0627\0627\064A\064A01234567890123456789.')) );
+
+
+CREATE INDEX IF NOT EXISTS restaurants_fts_idx
+    ON restaurants USING gin ( to_tsvector('english', translate(name || ' ' || coalesce(local_name, ''),
+        U&'\0623\0625\0622\0671\0649\06CC\0660\0661\0662\0663\0664\0665\0666\0667\0668\0669\06F0\06F1\06F2\06F3\06F4\06F5\06F6\06F7\06F8\06F9\066B\064B\064C\064D\064E\064F\0650\0651\0652\0653\0654\0655\0656\0657\0658\0659\065A\065B\065C\065D\065E\065F\0670\0640',
+        U&'\0627\0627\0627\0627\064A\064A01234567890123456789.')) );
+
 -- CREATE INDEX IF NOT EXISTS categories_embedding_idx
 --     ON categories USING ivfflat ( embedding vector_cosine_ops )
 --     WITH (lists = 100);